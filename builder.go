@@ -0,0 +1,267 @@
+package zipext
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/enr/go-files/files"
+)
+
+// SourceOptions configures how the files of a source added to a Builder
+// are selected and compressed. Fields behave as in CreateOptions.
+type SourceOptions struct {
+	Exclusions      []string
+	ExcludePatterns []string
+	IncludePatterns []string
+	IgnoreFiles     []string
+	StoreSymlinks   bool
+	Directories     bool
+	Compression     *Compression
+}
+
+// Builder assembles a zip from several sources: directories, single files
+// and generated contents, each with its own internal path.
+// Sources are read only when the zip is written, in one pass.
+type Builder struct {
+	cfg     createConfig
+	sources []builderSource
+}
+
+// builderSource lists the entries of a source, given the configuration of
+// the zip being written.
+type builderSource func(ctx context.Context, cfg createConfig) ([]createEntry, error)
+
+// NewBuilder returns an empty Builder. Progress, Reproducible, ModTime and
+// Concurrency of opts apply to the whole zip, the other options are used
+// for the sources added without options. Flat and Prefix are ignored, as
+// every source has its own prefix.
+func NewBuilder(opts CreateOptions) (*Builder, error) {
+	cfg, err := newCreateConfig("", opts)
+	if err != nil {
+		return nil, err
+	}
+	cfg.createBaseDir = false
+	return &Builder{cfg: cfg}, nil
+}
+
+// AddDir adds the contents of the directory src under prefix, which can
+// hold several levels, such as "myapp-1.2/lib". An empty prefix puts them
+// at the root of the zip.
+func (b *Builder) AddDir(src string, prefix string) error {
+	return b.addDir(src, prefix, b.cfg)
+}
+
+// AddDirWithOptions is like AddDir but selects and compresses the files of
+// src using opts instead of the options of the Builder.
+func (b *Builder) AddDirWithOptions(src string, prefix string, opts SourceOptions) error {
+	cfg, err := b.sourceConfig(opts)
+	if err != nil {
+		return err
+	}
+	return b.addDir(src, prefix, cfg)
+}
+
+// sourceConfig returns the configuration of the Builder with the options of
+// a single source.
+func (b *Builder) sourceConfig(opts SourceOptions) (createConfig, error) {
+	cfg := b.cfg
+	if err := cfg.setSource(opts); err != nil {
+		return createConfig{}, err
+	}
+	return cfg, nil
+}
+
+func (b *Builder) addDir(src string, prefix string, cfg createConfig) error {
+	if prefix != "" {
+		var err error
+		if prefix, err = cleanPrefix(prefix); err != nil {
+			return err
+		}
+	}
+	if !files.IsDir(src) {
+		return fmt.Errorf("invalid path %s", src)
+	}
+	cfg.prefix = prefix
+	b.sources = append(b.sources, func(ctx context.Context, out createConfig) ([]createEntry, error) {
+		scan := cfg
+		scan.zipPath = out.zipPath
+		scan.tempPath = out.tempPath
+		return scanInput(ctx, src, scan)
+	})
+	return nil
+}
+
+// AddFile adds the file src as the entry name.
+func (b *Builder) AddFile(src string, name string) error {
+	return b.addFile(src, name, b.cfg)
+}
+
+// AddFileWithOptions is like AddFile but compresses src, and stores it as a
+// link if it is one and StoreSymlinks is set, using opts instead of the
+// options of the Builder. The options selecting files are ignored.
+func (b *Builder) AddFileWithOptions(src string, name string, opts SourceOptions) error {
+	cfg, err := b.sourceConfig(opts)
+	if err != nil {
+		return err
+	}
+	return b.addFile(src, name, cfg)
+}
+
+func (b *Builder) addFile(src string, name string, cfg createConfig) error {
+	if err := validEntryName(name); err != nil {
+		return err
+	}
+	stat := os.Stat
+	if cfg.storeSymlinks {
+		stat = os.Lstat
+	}
+	fi, err := stat(src)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		return fmt.Errorf("%s is a directory", src)
+	}
+	b.add(createEntry{path: src, info: fi, internalPath: name}, cfg)
+	return nil
+}
+
+// AddReader adds the contents read from r as the entry name, with the mode
+// and modification time of info. If info is nil the entry gets mode 0644
+// and the current time. r is read once, when the zip is written.
+func (b *Builder) AddReader(name string, r io.Reader, info fs.FileInfo) error {
+	return b.addReader(name, r, info, b.cfg)
+}
+
+// AddReaderWithOptions is like AddReader but compresses the entry using the
+// Compression of opts instead of the one of the Builder.
+func (b *Builder) AddReaderWithOptions(name string, r io.Reader, info fs.FileInfo, opts SourceOptions) error {
+	cfg, err := b.sourceConfig(opts)
+	if err != nil {
+		return err
+	}
+	return b.addReader(name, r, info, cfg)
+}
+
+func (b *Builder) addReader(name string, r io.Reader, info fs.FileInfo, cfg createConfig) error {
+	if err := validEntryName(name); err != nil {
+		return err
+	}
+	if info == nil {
+		info = &fileInfo{name: path.Base(name), mode: 0644, modTime: time.Now()}
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s is a directory", name)
+	}
+	b.add(createEntry{info: info, internalPath: name, reader: func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(r), nil
+	}}, cfg)
+	return nil
+}
+
+// AddBytes adds data as the entry name, with mode 0644 and the current time.
+func (b *Builder) AddBytes(name string, data []byte) error {
+	return b.addBytes(name, data, b.cfg)
+}
+
+// AddBytesWithOptions is like AddBytes but compresses the entry using the
+// Compression of opts instead of the one of the Builder.
+func (b *Builder) AddBytesWithOptions(name string, data []byte, opts SourceOptions) error {
+	cfg, err := b.sourceConfig(opts)
+	if err != nil {
+		return err
+	}
+	return b.addBytes(name, data, cfg)
+}
+
+func (b *Builder) addBytes(name string, data []byte, cfg createConfig) error {
+	if err := validEntryName(name); err != nil {
+		return err
+	}
+	info := &fileInfo{name: path.Base(name), size: int64(len(data)), mode: 0644, modTime: time.Now()}
+	b.add(createEntry{info: info, internalPath: name, reader: func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(data)), nil
+	}}, cfg)
+	return nil
+}
+
+// add adds the single entry e, compressed with the options of cfg.
+func (b *Builder) add(e createEntry, cfg createConfig) {
+	e.compression = cfg.compression
+	e.storeSymlinks = cfg.storeSymlinks
+	b.sources = append(b.sources, func(ctx context.Context, out createConfig) ([]createEntry, error) {
+		return []createEntry{e}, nil
+	})
+}
+
+// Create writes the zip at zipPath, replacing it only once complete.
+func (b *Builder) Create(zipPath string) error {
+	return b.CreateContext(context.Background(), zipPath)
+}
+
+// CreateContext is like Create but stops as soon as ctx is done, leaving
+// any file already at zipPath untouched.
+func (b *Builder) CreateContext(ctx context.Context, zipPath string) error {
+	outFilePath := strings.TrimSpace(zipPath)
+	if outFilePath == "" {
+		return fmt.Errorf("destination is empty")
+	}
+	cfg := b.cfg
+	cfg.zipPath = outFilePath
+	return createFile(outFilePath, func(f *os.File) error {
+		cfg.tempPath = f.Name()
+		return b.write(ctx, f, cfg)
+	})
+}
+
+// CreateTo writes the zip in w, which is not closed.
+func (b *Builder) CreateTo(w io.Writer) error {
+	return b.write(context.Background(), w, b.cfg)
+}
+
+func (b *Builder) write(ctx context.Context, w io.Writer, cfg createConfig) error {
+	var entries []createEntry
+	for _, source := range b.sources {
+		found, err := source(ctx, cfg)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, found...)
+	}
+	entries, err := uniqueEntries(entries)
+	if err != nil {
+		return err
+	}
+	zw := zip.NewWriter(w)
+	if err := writeEntries(ctx, zw, entries, cfg); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// uniqueEntries drops the directories added by more than one source,
+// failing if a file is.
+func uniqueEntries(entries []createEntry) ([]createEntry, error) {
+	names := make(map[string]bool, len(entries))
+	unique := entries[:0]
+	for _, e := range entries {
+		if names[e.internalPath] {
+			if strings.HasSuffix(e.internalPath, "/") {
+				continue
+			}
+			return nil, fmt.Errorf("%s: duplicate entry", e.internalPath)
+		}
+		names[e.internalPath] = true
+		unique = append(unique, e)
+	}
+	return unique, nil
+}
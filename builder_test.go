@@ -0,0 +1,134 @@
+package zipext
+
+import (
+	"archive/zip"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuilder(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "app", "bin", "run.sh"), "run")
+	writeTestFile(t, filepath.Join(dir, "app", "bin", "debug.log"), "log")
+	writeTestFile(t, filepath.Join(dir, "libs", "a.jar"), "jar")
+	writeTestFile(t, filepath.Join(dir, "libs", "notes.txt"), strings.Repeat("notes ", 1000))
+	writeTestFile(t, filepath.Join(dir, "LICENSE"), "license")
+	writeTestFile(t, filepath.Join(dir, "launcher.jar"), strings.Repeat("launcher ", 100))
+	b, err := NewBuilder(CreateOptions{Reproducible: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddDirWithOptions(filepath.Join(dir, "app"), "myapp-1.0", SourceOptions{ExcludePatterns: []string{"*.log"}, Directories: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddDirWithOptions(filepath.Join(dir, "libs"), "myapp-1.0/lib", SourceOptions{Compression: &Compression{StoreExtensions: []string{".jar"}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddFile(filepath.Join(dir, "LICENSE"), "myapp-1.0/LICENSE.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddReader("myapp-1.0/VERSION", strings.NewReader("1.0"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddBytes("myapp-1.0/empty", nil); err != nil {
+		t.Fatal(err)
+	}
+	stored := SourceOptions{Compression: &Compression{StoreExtensions: CompressedExtensions}}
+	if err := b.AddFileWithOptions(filepath.Join(dir, "launcher.jar"), "myapp-1.0/launcher.jar", stored); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddBytesWithOptions("myapp-1.0/VERSION.gz", []byte(strings.Repeat("1.0", 100)), stored); err != nil {
+		t.Fatal(err)
+	}
+	zipPath := filepath.Join(dir, "myapp.zip")
+	if err := b.Create(zipPath); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"myapp-1.0/", "myapp-1.0/LICENSE.txt", "myapp-1.0/VERSION", "myapp-1.0/VERSION.gz", "myapp-1.0/bin/", "myapp-1.0/bin/run.sh", "myapp-1.0/empty", "myapp-1.0/launcher.jar", "myapp-1.0/lib/a.jar", "myapp-1.0/lib/notes.txt"}
+	names := listEntries(t, zipPath)
+	if strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected %v but found %v", expected, names)
+	}
+	if got := readEntry(t, zipPath, "myapp-1.0/VERSION"); got != "1.0" {
+		t.Errorf("expected 1.0 but found %q", got)
+	}
+	entries := zipEntries(t, zipPath)
+	if entries["myapp-1.0/lib/a.jar"].Method != zip.Store || entries["myapp-1.0/bin/run.sh"].Method != zip.Deflate {
+		t.Error("expected compression of each source")
+	}
+	if entries["myapp-1.0/launcher.jar"].Method != zip.Store || entries["myapp-1.0/VERSION.gz"].Method != zip.Store || entries["myapp-1.0/LICENSE.txt"].Method != zip.Deflate {
+		t.Error("expected single entries compressed with their own options")
+	}
+	if notes := entries["myapp-1.0/lib/notes.txt"]; notes.Method != zip.Deflate || notes.CompressedSize64*10 > notes.UncompressedSize64 {
+		t.Errorf("expected notes.txt deflated at the default level, got %d bytes", notes.CompressedSize64)
+	}
+}
+
+func TestBuilderDuplicateEntry(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "src", "a.txt"), "a")
+	b, err := NewBuilder(CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddDir(filepath.Join(dir, "src"), ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddBytes("a.txt", []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	zipPath := filepath.Join(dir, "dup.zip")
+	if err := b.Create(zipPath); err == nil || !strings.Contains(err.Error(), "duplicate") {
+		t.Errorf("expected duplicate entry error but got %v", err)
+	}
+	assertNoTempFiles(t, dir)
+}
+
+func TestBuilderStoreIfLargerReader(t *testing.T) {
+	b, err := NewBuilder(CreateOptions{Compression: &Compression{StoreIfLarger: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// too short to be made smaller, so stored reading it only once
+	if err := b.AddReader("short.txt", strings.NewReader("x"), nil); err != nil {
+		t.Fatal(err)
+	}
+	zipPath := filepath.Join(t.TempDir(), "short.zip")
+	if err := b.Create(zipPath); err != nil {
+		t.Fatal(err)
+	}
+	if got := readEntry(t, zipPath, "short.txt"); got != "x" {
+		t.Errorf("expected x but found %q", got)
+	}
+	if zipEntries(t, zipPath)["short.txt"].Method != zip.Store {
+		t.Error("expected short.txt stored")
+	}
+}
+
+func TestBuilderInvalidSources(t *testing.T) {
+	dir := t.TempDir()
+	b, err := NewBuilder(CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddDir(filepath.Join(dir, "missing"), "x"); err == nil {
+		t.Error("expected error for a missing directory")
+	}
+	if err := b.AddFile(dir, "x"); err == nil {
+		t.Error("expected error adding a directory as file")
+	}
+	for _, name := range []string{"/abs", "../evil", "a/../../evil"} {
+		if err := b.AddBytes(name, nil); err == nil {
+			t.Errorf("expected error for name %q", name)
+		}
+	}
+	if err := b.AddDirWithOptions(dir, "", SourceOptions{Exclusions: []string{"("}}); err == nil {
+		t.Error("expected error for an invalid exclusion")
+	}
+	writeTestFile(t, filepath.Join(dir, "x"), "x")
+	invalid := SourceOptions{Compression: &Compression{Level: CompressionLevel(10)}}
+	if err := b.AddFileWithOptions(filepath.Join(dir, "x"), "x", invalid); err == nil {
+		t.Error("expected error for an invalid compression level")
+	}
+}
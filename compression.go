@@ -0,0 +1,269 @@
+package zipext
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+// Compression selects how CreateWithOptions compresses the entries.
+type Compression struct {
+	// Level is the deflate level, from flate.NoCompression (0) to
+	// flate.BestCompression (9), or flate.DefaultCompression (-1).
+	// If nil files are deflated at the default level.
+	Level *int
+	// StoreExtensions lists the extensions, such as ".jpg" or ".gz", of the
+	// files stored without compression. Matching ignores case.
+	StoreExtensions []string
+	// StoreIfLarger deflates the files and stores them uncompressed when
+	// deflating does not make them smaller.
+	StoreIfLarger bool
+}
+
+// CompressedExtensions are extensions of formats already compressed, which
+// are usually better stored.
+var CompressedExtensions = []string{
+	".7z", ".bz2", ".ear", ".gif", ".gz", ".jar", ".jpeg", ".jpg", ".mp3",
+	".mp4", ".png", ".tgz", ".war", ".webp", ".xz", ".zip", ".zst",
+}
+
+// CompressionLevel returns a pointer to level, to set Compression.Level.
+func CompressionLevel(level int) *int {
+	return &level
+}
+
+func (c *Compression) validate() error {
+	if c.Level != nil && (*c.Level < flate.DefaultCompression || *c.Level > flate.BestCompression) {
+		return fmt.Errorf("invalid compression level %d", *c.Level)
+	}
+	return nil
+}
+
+// method returns the compression method for the file named name.
+func (c *Compression) method(name string) uint16 {
+	ext := strings.ToLower(path.Ext(name))
+	for _, e := range c.StoreExtensions {
+		if ext != "" && strings.ToLower(e) == ext {
+			return zip.Store
+		}
+	}
+	return zip.Deflate
+}
+
+// deflateLevel returns the deflate level of c, the default one if c or its
+// Level is nil.
+func deflateLevel(c *Compression) int {
+	if c == nil || c.Level == nil {
+		return flate.DefaultCompression
+	}
+	return *c.Level
+}
+
+// spoolMemory is the size over which a spool moves its data to a file.
+const spoolMemory = 4 << 20
+
+// spool buffers data in memory, spilling it to a temporary file when it
+// grows over spoolMemory bytes.
+type spool struct {
+	buf  bytes.Buffer
+	file *os.File
+}
+
+func (s *spool) Write(p []byte) (int, error) {
+	if s.file == nil && s.buf.Len()+len(p) > spoolMemory {
+		f, err := ioutil.TempFile("", "zipext-")
+		if err != nil {
+			return 0, err
+		}
+		s.file = f
+		if _, err := s.buf.WriteTo(f); err != nil {
+			return 0, err
+		}
+	}
+	if s.file != nil {
+		return s.file.Write(p)
+	}
+	return s.buf.Write(p)
+}
+
+// reader returns a reader of the data written so far.
+func (s *spool) reader() (io.Reader, error) {
+	if s.file == nil {
+		return bytes.NewReader(s.buf.Bytes()), nil
+	}
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return s.file, nil
+}
+
+// Close releases the memory and removes the temporary file, if any.
+func (s *spool) Close() error {
+	s.buf = bytes.Buffer{}
+	if s.file == nil {
+		return nil
+	}
+	s.file.Close()
+	return os.Remove(s.file.Name())
+}
+
+// spooled holds the data of an entry, deflated or stored, ahead of writing
+// the entry.
+type spooled struct {
+	data           *spool
+	method         uint16
+	crc32          uint32
+	size           uint64
+	compressedSize uint64
+}
+
+// deflate compresses r at level into a spool.
+func deflate(ctx context.Context, r io.Reader, level int, p Progress) (*spooled, error) {
+	d := &spooled{data: &spool{}, method: zip.Deflate}
+	cw := &countWriter{w: d.data}
+	fw, err := flate.NewWriter(cw, level)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.copy(ctx, fw, r, p); err != nil {
+		return nil, err
+	}
+	d.compressedSize = uint64(cw.n)
+	return d, nil
+}
+
+// store copies r as it is into a spool.
+func store(ctx context.Context, r io.Reader, p Progress) (*spooled, error) {
+	d := &spooled{data: &spool{}, method: zip.Store}
+	if err := d.copy(ctx, nopWriteCloser{d.data}, r, p); err != nil {
+		return nil, err
+	}
+	d.compressedSize = d.size
+	return d, nil
+}
+
+// copy copies r in w, closing it, and sets the checksum and size of d.
+func (d *spooled) copy(ctx context.Context, w io.WriteCloser, r io.Reader, p Progress) error {
+	h := crc32.NewIEEE()
+	n, err := io.Copy(io.MultiWriter(w, h), &contextReader{ctx: ctx, r: &progressReader{r: r, p: p}})
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		d.data.Close()
+		return err
+	}
+	d.crc32 = h.Sum32()
+	d.size = uint64(n)
+	return nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// writeRaw writes d as it is spooled as the entry described by header.
+func (d *spooled) writeRaw(tw *zip.Writer, header *zip.FileHeader) error {
+	header.Method = d.method
+	header.CRC32 = d.crc32
+	header.CompressedSize64 = d.compressedSize
+	header.UncompressedSize64 = d.size
+	prepareRaw(header)
+	w, err := tw.CreateRaw(header)
+	if err != nil {
+		return err
+	}
+	r, err := d.data.reader()
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// prepareRaw completes header as zip.Writer.CreateHeader does before
+// writing it with CreateRaw: versions, UTF-8 flag and modification time,
+// also as extended timestamp.
+func prepareRaw(header *zip.FileHeader) {
+	if needsUTF8(header.Name) {
+		header.Flags |= 0x800
+	}
+	header.CreatorVersion = header.CreatorVersion&0xff00 | 20
+	header.ReaderVersion = 20
+	if header.Modified.IsZero() {
+		return
+	}
+	t := header.Modified
+	header.ModifiedDate = uint16(t.Day() + int(t.Month())<<5 + (t.Year()-1980)<<9)
+	header.ModifiedTime = uint16(t.Second()/2 + t.Minute()<<5 + t.Hour()<<11)
+	var extra [9]byte
+	binary.LittleEndian.PutUint16(extra[0:], extTimeExtraID)
+	binary.LittleEndian.PutUint16(extra[2:], 5)
+	extra[4] = 1
+	binary.LittleEndian.PutUint32(extra[5:], uint32(t.Unix()))
+	header.Extra = append(header.Extra, extra[:]...)
+}
+
+// needsUTF8 reports whether name is valid UTF-8 not compatible with CP-437,
+// the rule CreateHeader uses to set the UTF-8 flag.
+func needsUTF8(name string) bool {
+	require := false
+	for i := 0; i < len(name); {
+		r, size := utf8.DecodeRuneInString(name[i:])
+		i += size
+		if r < 0x20 || r > 0x7d || r == 0x5c {
+			if r == utf8.RuneError && size == 1 {
+				return false
+			}
+			require = true
+		}
+	}
+	return require
+}
+
+// writeStored writes the deflated d uncompressed, inflating it, as the
+// entry described by header.
+func (d *spooled) writeStored(ctx context.Context, tw *zip.Writer, header *zip.FileHeader) error {
+	header.Method = zip.Store
+	header.CRC32 = d.crc32
+	header.CompressedSize64 = d.size
+	header.UncompressedSize64 = d.size
+	prepareRaw(header)
+	w, err := tw.CreateRaw(header)
+	if err != nil {
+		return err
+	}
+	r, err := d.data.reader()
+	if err != nil {
+		return err
+	}
+	fr := flate.NewReader(r)
+	defer fr.Close()
+	_, err = io.Copy(w, &contextReader{ctx: ctx, r: fr})
+	return err
+}
@@ -0,0 +1,127 @@
+package zipext
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCreateWithOptionsCompression(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	random := make([]byte, 64*1024)
+	rand.New(rand.NewSource(1)).Read(random)
+	writeTestFile(t, filepath.Join(src, "photo.JPG"), "jpeg contents jpeg contents jpeg contents")
+	writeTestFile(t, filepath.Join(src, "text.txt"), strings.Repeat("compressible ", 1000))
+	writeTestFile(t, filepath.Join(src, "random.bin"), string(random))
+	zipPath := filepath.Join(dir, "compression.zip")
+	opts := CreateOptions{Compression: &Compression{
+		Level:           CompressionLevel(flate.BestCompression),
+		StoreExtensions: CompressedExtensions,
+		StoreIfLarger:   true,
+	}}
+	if err := CreateWithOptions(src, zipPath, opts); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]uint16{
+		"src/photo.JPG":  zip.Store,
+		"src/text.txt":   zip.Deflate,
+		"src/random.bin": zip.Store,
+	}
+	for name, f := range zipEntries(t, zipPath) {
+		if f.Method != expected[name] {
+			t.Errorf("expected method %d for %s but got %d", expected[name], name, f.Method)
+		}
+	}
+
+	destDir := filepath.Join(dir, "dest")
+	if err := Extract(zipPath, destDir); err != nil {
+		t.Fatal(err)
+	}
+	assertFileContent(t, filepath.Join(destDir, "src", "text.txt"), strings.Repeat("compressible ", 1000))
+	assertFileContent(t, filepath.Join(destDir, "src", "random.bin"), string(random))
+}
+
+func TestCreateWithOptionsCompressionDefaultLevel(t *testing.T) {
+	dir := t.TempDir()
+	text := strings.Repeat("compressible ", 5000)
+	writeTestFile(t, filepath.Join(dir, "src", "text.txt"), text)
+	zipPath := filepath.Join(dir, "default.zip")
+	opts := CreateOptions{Compression: &Compression{StoreExtensions: CompressedExtensions}}
+	if err := CreateWithOptions(filepath.Join(dir, "src"), zipPath, opts); err != nil {
+		t.Fatal(err)
+	}
+	f := zipEntries(t, zipPath)["src/text.txt"]
+	if f.Method != zip.Deflate || f.CompressedSize64*10 > f.UncompressedSize64 {
+		t.Errorf("expected text.txt compressed but got %d bytes out of %d", f.CompressedSize64, f.UncompressedSize64)
+	}
+}
+
+func TestCreateWithOptionsCompressionExplicitLevel(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "src")
+	writeTestFile(t, filepath.Join(src, "text.txt"), strings.Repeat("compressible ", 5000))
+	zips := map[string]*bytes.Buffer{}
+	for name, level := range map[string]*int{"unset": nil, "default": CompressionLevel(flate.DefaultCompression), "none": CompressionLevel(flate.NoCompression)} {
+		zips[name] = &bytes.Buffer{}
+		opts := CreateOptions{Reproducible: true, Compression: &Compression{Level: level}}
+		if err := CreateTo(zips[name], src, opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !bytes.Equal(zips["unset"].Bytes(), zips["default"].Bytes()) {
+		t.Error("expected the same zip with an unset and a default level")
+	}
+	zr, err := zip.NewReader(bytes.NewReader(zips["none"].Bytes()), int64(zips["none"].Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f := zr.File[0]; f.Method != zip.Deflate || f.CompressedSize64 < f.UncompressedSize64 {
+		t.Errorf("expected text.txt deflated without compression but got %d bytes out of %d", f.CompressedSize64, f.UncompressedSize64)
+	}
+}
+
+func TestCreateWithOptionsInvalidCompressionLevel(t *testing.T) {
+	zipPath := filepath.Join(t.TempDir(), "level.zip")
+	opts := CreateOptions{Compression: &Compression{Level: CompressionLevel(10)}}
+	if err := CreateWithOptions("testdata/files", zipPath, opts); err == nil {
+		t.Error("expected error for compression level 10")
+	}
+}
+
+func TestSpoolSpillsToFile(t *testing.T) {
+	data := bytes.Repeat([]byte("x"), spoolMemory+10)
+	s := &spool{}
+	if _, err := s.Write(data[:10]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Write(data[10:]); err != nil {
+		t.Fatal(err)
+	}
+	if s.file == nil {
+		t.Fatal("expected spool spilled to a temporary file")
+	}
+	r, err := s.reader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(read, data) {
+		t.Error("spool returned different data")
+	}
+	name := s.file.Name()
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Errorf("temporary file %s not removed", name)
+	}
+}
@@ -0,0 +1,315 @@
+package zipext
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CreateOptions configures CreateWithOptions.
+// The zero value behaves like Create.
+type CreateOptions struct {
+	// Flat puts the contents of a directory at the root of the zip,
+	// as CreateFlat does.
+	Flat bool
+	// Prefix, if not empty, is the internal path the contents of a
+	// directory are put under instead of its base name, e.g.
+	// "myapp-1.4.2" or "dist/myapp-1.4.2". A file is put under it.
+	// Directory entries are written for every level when Directories is
+	// set, for files too. Prefix takes precedence over Flat.
+	Prefix string
+	// Exclusions are regular expressions matched against the internal path
+	// of every file, as in CreateExcluding.
+	Exclusions []string
+	// ExcludePatterns are .gitignore style patterns, see PatternSet, of the
+	// files to leave out, matched against paths relative to the input
+	// directory.
+	ExcludePatterns []string
+	// IncludePatterns, if not empty, keeps only the files matching one of
+	// these patterns. Directories are walked anyway.
+	IncludePatterns []string
+	// IgnoreFiles names files, such as ".gitignore" or ".zipignore", whose
+	// patterns are added to ExcludePatterns for the directory containing
+	// them and its subdirectories.
+	IgnoreFiles []string
+	// StoreSymlinks stores symbolic links as link entries, holding the link
+	// target as content the way Info-ZIP does, instead of the content of
+	// the linked file.
+	StoreSymlinks bool
+	// Directories writes an entry, with mode and modification time, for
+	// every directory, so that empty directories are kept.
+	Directories bool
+	// Progress, if not nil, is notified about the entries being written.
+	Progress Progress
+	// Reproducible builds zips depending only on the input contents:
+	// entries are sorted, timestamps are clamped to ModTime, permissions
+	// are normalised to 0644 or 0755 and extra fields are dropped.
+	Reproducible bool
+	// ModTime is the time timestamps are clamped to in reproducible zips.
+	// If zero the SOURCE_DATE_EPOCH environment variable is used or, when
+	// it is missing, 1980-01-01.
+	ModTime time.Time
+	// Compression selects compression method and level of the entries.
+	// If nil files are deflated at the default level.
+	Compression *Compression
+	// Concurrency is the number of files compressed in parallel. The zip is
+	// byte for byte the same of a sequential build. Values lower than 2
+	// disable parallel compression.
+	Concurrency int
+}
+
+// CreateWithOptions build a zip containing inputPath using the given options.
+func CreateWithOptions(inputPath string, zipPath string, opts CreateOptions) error {
+	return CreateContext(context.Background(), inputPath, zipPath, opts)
+}
+
+// CreateContext is like CreateWithOptions but stops as soon as ctx is done,
+// leaving any file already at zipPath untouched.
+func CreateContext(ctx context.Context, inputPath string, zipPath string, opts CreateOptions) error {
+	cfg, err := newCreateConfig(zipPath, opts)
+	if err != nil {
+		return err
+	}
+	return createZip(ctx, inputPath, zipPath, cfg)
+}
+
+// CreateTo writes in w a zip containing inputPath using the given options,
+// so that it can be sent, e.g., as an HTTP response without a temporary
+// file. w is not closed.
+func CreateTo(w io.Writer, inputPath string, opts CreateOptions) error {
+	cfg, err := newCreateConfig("", opts)
+	if err != nil {
+		return err
+	}
+	return createTo(context.Background(), w, inputPath, cfg)
+}
+
+// newCreateConfig validates opts and converts them to a createConfig for
+// the zip at zipPath, empty if the zip is not a file.
+func newCreateConfig(zipPath string, opts CreateOptions) (createConfig, error) {
+	cfg := createConfig{
+		createBaseDir: !opts.Flat,
+		zipPath:       zipPath,
+		progress:      opts.Progress,
+		reproducible:  opts.Reproducible,
+		concurrency:   opts.Concurrency,
+	}
+	if err := cfg.setSource(opts.source()); err != nil {
+		return cfg, err
+	}
+	if opts.Prefix != "" {
+		prefix, err := cleanPrefix(opts.Prefix)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.createBaseDir = false
+		cfg.prefix = prefix
+	}
+	if opts.Reproducible {
+		modTime, err := reproducibleTime(opts.ModTime)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.modTime = modTime
+	}
+	return cfg, nil
+}
+
+// cleanPrefix validates the internal path prefix, refusing the ones
+// leading outside of the zip root, and removes the trailing slash.
+func cleanPrefix(prefix string) (string, error) {
+	if outsideRoot(prefix) {
+		return "", fmt.Errorf("invalid prefix %q", prefix)
+	}
+	return path.Clean(prefix), nil
+}
+
+// outsideRoot reports whether the internal path p is absolute or, once
+// cleaned, is the zip root or leads outside of it.
+func outsideRoot(p string) bool {
+	c := path.Clean(p)
+	return strings.HasPrefix(p, "/") || c == "." || c == ".." || strings.HasPrefix(c, "../")
+}
+
+// source returns the options of opts applying to the files of the input.
+func (opts CreateOptions) source() SourceOptions {
+	return SourceOptions{
+		Exclusions:      opts.Exclusions,
+		ExcludePatterns: opts.ExcludePatterns,
+		IncludePatterns: opts.IncludePatterns,
+		IgnoreFiles:     opts.IgnoreFiles,
+		StoreSymlinks:   opts.StoreSymlinks,
+		Directories:     opts.Directories,
+		Compression:     opts.Compression,
+	}
+}
+
+// setSource validates opts and applies them to cfg, replacing the options
+// of any previous source.
+func (cfg *createConfig) setSource(opts SourceOptions) error {
+	if err := cfg.compilePatterns(opts); err != nil {
+		return err
+	}
+	if opts.Compression != nil {
+		if err := opts.Compression.validate(); err != nil {
+			return err
+		}
+	}
+	cfg.ignoreFiles = opts.IgnoreFiles
+	cfg.storeSymlinks = opts.StoreSymlinks
+	cfg.directories = opts.Directories
+	cfg.compression = opts.Compression
+	return nil
+}
+
+// compilePatterns compiles the exclusions and the include and exclude
+// patterns of opts.
+func (cfg *createConfig) compilePatterns(opts SourceOptions) error {
+	var err error
+	if cfg.exclusions, err = NewMatcher(opts.Exclusions); err != nil {
+		return err
+	}
+	cfg.excludes, cfg.includes = nil, nil
+	if len(opts.ExcludePatterns) > 0 {
+		if cfg.excludes, err = NewPatternSet(opts.ExcludePatterns); err != nil {
+			return err
+		}
+	}
+	if len(opts.IncludePatterns) > 0 {
+		if cfg.includes, err = NewPatternSet(opts.IncludePatterns); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreateFromFS writes in w a zip of the files under root in fsys, such as
+// an embed.FS or an fstest.MapFS. Entries are named relative to root, as
+// CreateFlat does, and written in lexical order. w is not closed.
+func CreateFromFS(fsys fs.FS, root string, w io.Writer) error {
+	entries, err := scanFS(fsys, root)
+	if err != nil {
+		return err
+	}
+	zw := zip.NewWriter(w)
+	if err := writeEntries(context.Background(), zw, entries, createConfig{}); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// scanFS lists the files under root in fsys. Links are followed if fsys
+// does, links to directories are skipped.
+func scanFS(fsys fs.FS, root string) ([]createEntry, error) {
+	var entries []createEntry
+	err := fs.WalkDir(fsys, root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := fs.Stat(fsys, p)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		name := path.Base(p)
+		if p != root {
+			name = strings.TrimPrefix(p, root+"/")
+			if root == "." {
+				name = p
+			}
+		}
+		entries = append(entries, createEntry{path: p, info: info, internalPath: name, fsys: fsys})
+		return nil
+	})
+	return entries, err
+}
+
+// followSymlink returns the info of the file linked by the symbolic link
+// fp, so that its contents are stored. Links to directories and broken links
+// keep their own info.
+func followSymlink(fp string, fi os.FileInfo) os.FileInfo {
+	target, err := os.Stat(fp)
+	if err != nil || target.IsDir() {
+		return fi
+	}
+	return target
+}
+
+// newHeader returns the zip header for e.
+func newHeader(e createEntry, cfg createConfig) (*zip.FileHeader, error) {
+	header, err := zip.FileInfoHeader(e.info)
+	if err != nil {
+		return nil, err
+	}
+	header.Name = e.internalPath
+	header.Method = zip.Deflate
+	if e.info.IsDir() || e.storeSymlinks && e.isSymlink() {
+		header.Method = zip.Store
+	} else if e.compression != nil {
+		header.Method = e.compression.method(e.internalPath)
+	}
+	header.UncompressedSize64 = uint64(e.size())
+	if cfg.reproducible {
+		normalizeHeader(header, cfg.modTime)
+	}
+	return header, nil
+}
+
+// normalizeHeader removes from header what depends on the host: timestamps
+// are clamped to modTime and permissions are reduced to 0644 or 0755.
+func normalizeHeader(header *zip.FileHeader, modTime time.Time) {
+	if header.Modified.After(modTime) {
+		header.Modified = modTime
+	}
+	header.Modified = header.Modified.UTC()
+	mode := header.Mode()
+	switch {
+	case mode.IsDir():
+		header.SetMode(os.ModeDir | 0755)
+	case mode&os.ModeSymlink != 0:
+		header.SetMode(os.ModeSymlink | 0777)
+	case mode&0111 != 0:
+		header.SetMode(0755)
+	default:
+		header.SetMode(0644)
+	}
+	header.Extra = nil
+}
+
+// reproducibleTime returns the time timestamps are clamped to: modTime if
+// set, otherwise SOURCE_DATE_EPOCH or, when it is missing, the zip epoch.
+func reproducibleTime(modTime time.Time) (time.Time, error) {
+	if !modTime.IsZero() {
+		return modTime.UTC(), nil
+	}
+	sde := strings.TrimSpace(os.Getenv("SOURCE_DATE_EPOCH"))
+	if sde == "" {
+		return time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC), nil
+	}
+	secs, err := strconv.ParseInt(sde, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid SOURCE_DATE_EPOCH %s", sde)
+	}
+	return time.Unix(secs, 0).UTC(), nil
+}
+
+// openSymlink returns a reader of the target of the link fp, the contents
+// of a link entry.
+func openSymlink(fp string) (io.ReadCloser, error) {
+	target, err := os.Readlink(fp)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(strings.NewReader(filepath.ToSlash(target))), nil
+}
@@ -0,0 +1,320 @@
+package zipext
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTestFile(t *testing.T, path string, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func zipEntries(t *testing.T, zipPath string) map[string]*zip.File {
+	t.Helper()
+	entries := map[string]*zip.File{}
+	err := Walk(zipPath, func(f *zip.File, err error) error {
+		if err != nil {
+			return err
+		}
+		entries[f.Name] = f
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("error walking %s: %v", zipPath, err)
+	}
+	return entries
+}
+
+func TestCreateWithOptionsStoreSymlinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks require privileges")
+	}
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	writeTestFile(t, filepath.Join(src, "sub", "data.txt"), "data")
+	if err := os.Symlink(filepath.Join("sub", "data.txt"), filepath.Join(src, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("missing", filepath.Join(src, "broken")); err != nil {
+		t.Fatal(err)
+	}
+	zipPath := filepath.Join(dir, "links.zip")
+	if err := CreateWithOptions(src, zipPath, CreateOptions{StoreSymlinks: true}); err != nil {
+		t.Fatal(err)
+	}
+	entries := zipEntries(t, zipPath)
+	for _, name := range []string{"src/link", "src/broken"} {
+		f, ok := entries[name]
+		if !ok {
+			t.Fatalf("expected %s not found in zip", name)
+		}
+		if f.Mode()&os.ModeSymlink == 0 {
+			t.Errorf("expected %s stored as symlink but mode is %v", name, f.Mode())
+		}
+	}
+
+	destDir := filepath.Join(dir, "dest")
+	if err := Extract(zipPath, destDir); err != nil {
+		t.Fatal(err)
+	}
+	target, err := os.Readlink(filepath.Join(destDir, "src", "link"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target != filepath.Join("sub", "data.txt") {
+		t.Errorf("unexpected link target %s", target)
+	}
+	assertFileContent(t, filepath.Join(destDir, "src", "link"), "data")
+}
+
+func TestCreateContextCancelled(t *testing.T) {
+	dir := t.TempDir()
+	zipPath := filepath.Join(dir, "cancelled.zip")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := CreateContext(ctx, "testdata/files", zipPath, CreateOptions{})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled but got %v", err)
+	}
+	if _, err := os.Stat(zipPath); !os.IsNotExist(err) {
+		t.Errorf("partial zip %s not removed", zipPath)
+	}
+}
+
+func TestCreateContextKeepsExistingZip(t *testing.T) {
+	dir := t.TempDir()
+	zipPath := filepath.Join(dir, "existing.zip")
+	writeTestFile(t, zipPath, "previous")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := CreateContext(ctx, "testdata/files", zipPath, CreateOptions{}); err == nil {
+		t.Fatal("expected error")
+	}
+	assertFileContent(t, zipPath, "previous")
+	assertNoTempFiles(t, dir)
+}
+
+func TestCreateReplacesZipInInput(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "a.txt"), "a")
+	zipPath := filepath.Join(dir, "self.zip")
+	writeTestFile(t, zipPath, "previous")
+	if err := os.Chmod(zipPath, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := CreateFlat(dir, zipPath); err != nil {
+		t.Fatal(err)
+	}
+	names := listEntries(t, zipPath)
+	if len(names) != 1 || names[0] != "a.txt" {
+		t.Errorf("expected only a.txt but got %v", names)
+	}
+	if runtime.GOOS != "windows" {
+		fi, err := os.Stat(zipPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode().Perm() != 0600 {
+			t.Errorf("expected mode 0600 kept but got %v", fi.Mode().Perm())
+		}
+	}
+}
+
+func TestContextReaderStopsCopy(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &contextReader{ctx: ctx, r: strings.NewReader(strings.Repeat("x", 64))}
+	buf := make([]byte, 8)
+	if _, err := r.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if _, err := io.Copy(ioutil.Discard, r); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled but got %v", err)
+	}
+}
+
+func TestCreateWithOptionsDirectories(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	writeTestFile(t, filepath.Join(src, "sub", "a.txt"), "a")
+	empty := filepath.Join(src, "empty")
+	createDir(empty, t)
+	modified := time.Date(2018, 2, 3, 4, 5, 6, 0, time.UTC)
+	if err := os.Chtimes(empty, modified, modified); err != nil {
+		t.Fatal(err)
+	}
+	zipPath := filepath.Join(dir, "dirs.zip")
+	if err := CreateWithOptions(src, zipPath, CreateOptions{Directories: true}); err != nil {
+		t.Fatal(err)
+	}
+	entries := zipEntries(t, zipPath)
+	for _, name := range []string{"src/", "src/sub/", "src/empty/", "src/sub/a.txt"} {
+		if _, ok := entries[name]; !ok {
+			t.Errorf("expected %s not found in zip %v", name, entries)
+		}
+	}
+
+	destDir := filepath.Join(dir, "dest")
+	if err := Extract(zipPath, destDir); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(filepath.Join(destDir, "src", "empty"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fi.IsDir() || !fi.ModTime().Equal(modified) {
+		t.Errorf("empty directory not restored: dir %v mtime %v", fi.IsDir(), fi.ModTime())
+	}
+}
+
+func TestCreateWithOptionsPrefix(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	writeTestFile(t, filepath.Join(src, "sub", "a.txt"), "a")
+	zipPath := filepath.Join(dir, "prefix.zip")
+	if err := CreateWithOptions(src, zipPath, CreateOptions{Prefix: "dist/myapp-1.4.2/", Directories: true}); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"dist/", "dist/myapp-1.4.2/", "dist/myapp-1.4.2/sub/", "dist/myapp-1.4.2/sub/a.txt"}
+	names := listEntries(t, zipPath)
+	if strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected %v but found %v", expected, names)
+	}
+
+	filePath := filepath.Join(dir, "file.zip")
+	if err := CreateWithOptions(filepath.Join(src, "sub", "a.txt"), filePath, CreateOptions{Prefix: "myapp"}); err != nil {
+		t.Fatal(err)
+	}
+	if names := listEntries(t, filePath); len(names) != 1 || names[0] != "myapp/a.txt" {
+		t.Errorf("expected myapp/a.txt but found %v", names)
+	}
+	if err := CreateWithOptions(filepath.Join(src, "sub", "a.txt"), filePath, CreateOptions{Prefix: "a/b", Directories: true}); err != nil {
+		t.Fatal(err)
+	}
+	expected = []string{"a/", "a/b/", "a/b/a.txt"}
+	if names := listEntries(t, filePath); strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected %v but found %v", expected, names)
+	}
+}
+
+func TestCreateWithOptionsInvalidPrefix(t *testing.T) {
+	dir := t.TempDir()
+	for _, prefix := range []string{"/abs", "..", "a/../../b", "./"} {
+		err := CreateWithOptions("testdata/files", filepath.Join(dir, "invalid.zip"), CreateOptions{Prefix: prefix})
+		if err == nil {
+			t.Errorf("expected error for prefix %q", prefix)
+		}
+	}
+}
+
+func TestCreateWithoutDirectories(t *testing.T) {
+	zipPath := filepath.Join(t.TempDir(), "nodirs.zip")
+	if err := Create("testdata/files", zipPath); err != nil {
+		t.Fatal(err)
+	}
+	for name := range zipEntries(t, zipPath) {
+		if strings.HasSuffix(name, "/") {
+			t.Errorf("unexpected directory entry %s", name)
+		}
+	}
+}
+
+func TestCreateWithOptionsReproducible(t *testing.T) {
+	dir := t.TempDir()
+	build := func(name string, order []string, perm os.FileMode, mtime time.Time) []byte {
+		src := filepath.Join(dir, name, "src")
+		for _, f := range order {
+			p := filepath.Join(src, f)
+			writeTestFile(t, p, "contents of "+f)
+			if err := os.Chmod(p, perm); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(p, mtime, mtime); err != nil {
+				t.Fatal(err)
+			}
+		}
+		zipPath := filepath.Join(dir, name+".zip")
+		opts := CreateOptions{Reproducible: true, Directories: true}
+		if err := CreateWithOptions(src, zipPath, opts); err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadFile(zipPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	os.Setenv("SOURCE_DATE_EPOCH", "1600000000")
+	defer os.Unsetenv("SOURCE_DATE_EPOCH")
+	first := build("first", []string{"b.txt", "a/z.txt", "a/y.txt"}, 0600, time.Now())
+	second := build("second", []string{"a/y.txt", "a/z.txt", "b.txt"}, 0644, time.Now().Add(time.Hour))
+	if !bytes.Equal(first, second) {
+		t.Error("reproducible zips are different")
+	}
+
+	expected := time.Unix(1600000000, 0)
+	names := []string{}
+	err := Walk(filepath.Join(dir, "first.zip"), func(f *zip.File, err error) error {
+		if err != nil {
+			return err
+		}
+		names = append(names, f.Name)
+		if !f.Modified.Equal(expected) {
+			t.Errorf("expected %s modified at %v but got %v", f.Name, expected, f.Modified)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sort.StringsAreSorted(names) {
+		t.Errorf("entries not sorted: %v", names)
+	}
+}
+
+func TestCreateWithOptionsInvalidSourceDateEpoch(t *testing.T) {
+	os.Setenv("SOURCE_DATE_EPOCH", "yesterday")
+	defer os.Unsetenv("SOURCE_DATE_EPOCH")
+	zipPath := filepath.Join(t.TempDir(), "invalid.zip")
+	if err := CreateWithOptions("testdata/files", zipPath, CreateOptions{Reproducible: true}); err == nil {
+		t.Error("expected error for invalid SOURCE_DATE_EPOCH")
+	}
+}
+
+func TestCreateToExtractFrom(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	writeTestFile(t, filepath.Join(src, "a.txt"), "a")
+	writeTestFile(t, filepath.Join(src, "sub", "b.txt"), "b")
+	var buf bytes.Buffer
+	if err := CreateTo(&buf, src, CreateOptions{Flat: true}); err != nil {
+		t.Fatal(err)
+	}
+	dest := filepath.Join(dir, "dest")
+	if _, err := ExtractFrom(bytes.NewReader(buf.Bytes()), int64(buf.Len()), dest, ExtractOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	assertFileContent(t, filepath.Join(dest, "a.txt"), "a")
+	assertFileContent(t, filepath.Join(dest, "sub", "b.txt"), "b")
+
+	if err := CreateTo(&buf, filepath.Join(dir, "missing"), CreateOptions{}); err == nil {
+		t.Error("expected error for missing input")
+	}
+}
@@ -0,0 +1,93 @@
+package zipext
+
+import (
+	"archive/zip"
+	"context"
+	"io"
+	"os"
+	"time"
+)
+
+// Entry describes a file or directory stored in an archive.
+// It exposes what client code needs without importing archive/zip, so that
+// entries can come from different kind of archives.
+type Entry struct {
+	// Name is the slash separated path of the entry inside the archive.
+	Name string
+	// Comment is the entry comment.
+	Comment string
+	// Method is the compression method, e.g. zip.Store or zip.Deflate.
+	Method uint16
+	// CompressedSize is the size of the stored data.
+	CompressedSize uint64
+	// UncompressedSize is the size of the contents.
+	UncompressedSize uint64
+	// Modified is the modification time, zero if unknown.
+	Modified time.Time
+	// Mode holds type and permission bits.
+	Mode os.FileMode
+	// CRC32 is the checksum of the contents.
+	CRC32 uint32
+
+	open func() (io.ReadCloser, error)
+	// file is the backing zip file, if any.
+	file *zip.File
+}
+
+// Open returns a reader of the entry contents.
+// The caller must close the reader. Entries visited by WalkEntries can be
+// opened only until walkFn returns.
+func (e *Entry) Open() (io.ReadCloser, error) {
+	return e.open()
+}
+
+// IsDir reports whether the entry describes a directory.
+func (e *Entry) IsDir() bool {
+	return e.Mode.IsDir()
+}
+
+// EntryFunc is the type of the function called for each entry visited by
+// WalkEntries. It follows the same rules of WalkFunc: entry may be nil when
+// err is not nil.
+type EntryFunc func(entry *Entry, err error) error
+
+// WalkEntries walks the zip file at path, calling walkFn for each entry.
+// All errors that arise visiting entries are filtered by walkFn.
+func WalkEntries(path string, walkFn EntryFunc) error {
+	return walk(context.Background(), path, Limits{}, walkFn)
+}
+
+// WalkEntriesContext is like WalkEntries but stops with the context error
+// as soon as ctx is done.
+func WalkEntriesContext(ctx context.Context, path string, walkFn EntryFunc) error {
+	return walk(ctx, path, Limits{}, walkFn)
+}
+
+// WalkEntriesWithLimits is like WalkEntries but checks every entry against
+// limits, as WalkWithLimits does.
+func WalkEntriesWithLimits(path string, limits Limits, walkFn EntryFunc) error {
+	return walk(context.Background(), path, limits, walkFn)
+}
+
+func newEntry(f *zip.File) *Entry {
+	return &Entry{
+		Name:             f.Name,
+		Comment:          f.Comment,
+		Method:           f.Method,
+		CompressedSize:   f.CompressedSize64,
+		UncompressedSize: f.UncompressedSize64,
+		Modified:         f.Modified,
+		Mode:             f.Mode(),
+		CRC32:            f.CRC32,
+		open:             f.Open,
+		file:             f,
+	}
+}
+
+func newEntries(files []*zip.File) []*Entry {
+	entries := make([]*Entry, len(files))
+	for i, f := range files {
+		entries[i] = newEntry(f)
+	}
+	return entries
+}
@@ -0,0 +1,105 @@
+package zipext
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWalkEntries(t *testing.T) {
+	modified := time.Date(2021, 3, 4, 5, 6, 8, 0, time.UTC)
+	zipPath := filepath.Join(t.TempDir(), "entries.zip")
+	writeTestZip(t, zipPath, []testEntry{
+		{name: "dir/", modified: modified},
+		{name: "dir/a.txt", body: "contents", modified: modified},
+	})
+	entries := map[string]*Entry{}
+	contents := map[string]string{}
+	err := WalkEntries(zipPath, func(entry *Entry, err error) error {
+		if err != nil {
+			return err
+		}
+		entries[entry.Name] = entry
+		r, err := entry.Open()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		data, err := ioutil.ReadAll(r)
+		contents[entry.Name] = string(data)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !entries["dir/"].IsDir() {
+		t.Error("expected dir/ to be a directory")
+	}
+	e := entries["dir/a.txt"]
+	if e.IsDir() || e.UncompressedSize != 8 || e.Method != zip.Deflate || !e.Modified.Equal(modified) {
+		t.Errorf("unexpected entry %+v", e)
+	}
+	if contents["dir/a.txt"] != "contents" {
+		t.Errorf(`expected "contents" but got "%s"`, contents["dir/a.txt"])
+	}
+}
+
+func TestWalkEntriesOpenError(t *testing.T) {
+	called := false
+	err := WalkEntries("testdata/not-a-zip.zip", func(entry *Entry, err error) error {
+		called = true
+		if entry != nil {
+			t.Errorf("expected nil entry but got %+v", entry)
+		}
+		return err
+	})
+	if err == nil || !called {
+		t.Errorf("expected error passed through walkFn, got %v", err)
+	}
+}
+
+func TestWalkEntriesContextCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	zipPath := filepath.Join(t.TempDir(), "walk.zip")
+	writeTestZip(t, zipPath, []testEntry{{name: "a.txt"}, {name: "b.txt"}})
+	visited := 0
+	err := WalkEntriesContext(ctx, zipPath, func(entry *Entry, err error) error {
+		if err != nil {
+			return err
+		}
+		visited++
+		cancel()
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled but got %v", err)
+	}
+	if visited != 1 {
+		t.Errorf("expected 1 visited entry but got %d", visited)
+	}
+}
+
+func TestWalkEntriesWithLimits(t *testing.T) {
+	zipPath := filepath.Join(t.TempDir(), "entries.zip")
+	writeTestZip(t, zipPath, []testEntry{{name: "a.txt"}, {name: "b.txt"}, {name: "c.txt"}})
+	visited := 0
+	err := WalkEntriesWithLimits(zipPath, Limits{MaxEntries: 2}, func(entry *Entry, err error) error {
+		if err != nil {
+			return err
+		}
+		visited++
+		return nil
+	})
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) || limitErr.Name != "c.txt" {
+		t.Errorf("expected LimitError on c.txt but got %v", err)
+	}
+	if visited != 2 {
+		t.Errorf("expected 2 visited entries but got %d", visited)
+	}
+}
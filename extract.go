@@ -0,0 +1,590 @@
+package zipext
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/enr/go-files/files"
+)
+
+// OverwritePolicy tells ExtractWithOptions what to do when the destination
+// of an entry already exists.
+type OverwritePolicy int
+
+const (
+	// OverwriteSkip leaves the existing file untouched and reports the entry
+	// as skipped. It is the policy used by Extract.
+	OverwriteSkip OverwritePolicy = iota
+	// OverwriteAlways replaces the existing file.
+	OverwriteAlways
+	// OverwriteFail stops the extraction with an error wrapping ErrConflict.
+	OverwriteFail
+	// OverwriteIfNewer replaces the existing file only if the entry
+	// modification time is after the one of the file on disk.
+	OverwriteIfNewer
+)
+
+// ErrConflict is returned (wrapped) by ExtractWithOptions when the policy is
+// OverwriteFail and the destination of an entry already exists.
+var ErrConflict = errors.New("destination already exists")
+
+// ExtractOptions configures ExtractWithOptions.
+// The zero value behaves like Extract.
+type ExtractOptions struct {
+	// Overwrite is the policy applied to destinations already existing.
+	Overwrite OverwritePolicy
+	// Umask holds the permission bits cleared from the mode stored in the
+	// archive, on top of the process umask.
+	Umask os.FileMode
+	// Limits caps the data extracted from the archive.
+	Limits Limits
+	// Progress, if not nil, is notified about the entries being extracted.
+	Progress Progress
+	// Concurrency is the number of files extracted in parallel. Directories
+	// and links are created first and all the paths are checked before
+	// writing any file. Values lower than 2 disable parallel extraction.
+	Concurrency int
+	// Atomic extracts into a staging directory next to extractPath, renamed
+	// to extractPath only once every entry has been extracted, so that a
+	// failure leaves nothing behind. extractPath must not exist, unless
+	// Replace is set.
+	Atomic bool
+	// Replace, in Atomic mode, replaces the directory already at
+	// extractPath, removing its contents.
+	Replace bool
+	// Filter, if not nil, selects the entries to extract. It is given the
+	// names stored in the archive.
+	Filter EntryFilter
+	// StripComponents removes the given number of leading path elements
+	// from the entry names, as tar --strip-components does, so that the
+	// contents of the base directory of a zip built by Create can be
+	// extracted directly into extractPath.
+	StripComponents int
+	// Rewrite, if not nil, maps the entry names, after StripComponents is
+	// applied, to the slash separated paths to extract them to. The results
+	// are checked as the names read from the archive.
+	Rewrite func(name string) string
+}
+
+// ExtractResult reports what ExtractWithOptions did.
+type ExtractResult struct {
+	// Skipped lists the names of the entries not written because of the
+	// overwrite policy.
+	Skipped []string
+}
+
+// ExtractWithOptions extracts contents of archivePath into the extractPath
+// using the given options.
+// The returned result is filled even when an error stops the extraction.
+func ExtractWithOptions(archivePath string, extractPath string, opts ExtractOptions) (ExtractResult, error) {
+	return ExtractContext(context.Background(), archivePath, extractPath, opts)
+}
+
+// ExtractContext is like ExtractWithOptions but stops as soon as ctx is
+// done, removing the partially written file.
+func ExtractContext(ctx context.Context, archivePath string, extractPath string, opts ExtractOptions) (ExtractResult, error) {
+	zipPath := strings.TrimSpace(archivePath)
+	destinationPath := strings.TrimSpace(extractPath)
+	if zipPath == "" || destinationPath == "" {
+		return ExtractResult{}, fmt.Errorf("path or destination is empty")
+	}
+	if !files.Exists(zipPath) {
+		return ExtractResult{}, fmt.Errorf("%s not found", zipPath)
+	}
+	f, err := os.Open(zipPath)
+	if err != nil {
+		return ExtractResult{}, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return ExtractResult{}, err
+	}
+	return extractFrom(ctx, f, fi.Size(), destinationPath, opts)
+}
+
+// ExtractFrom extracts the zip read from r, whose size is size, into
+// extractPath using the given options, so that a zip already in memory,
+// e.g. in a bytes.Reader, can be extracted without a temporary file.
+func ExtractFrom(r io.ReaderAt, size int64, extractPath string, opts ExtractOptions) (ExtractResult, error) {
+	return extractFrom(context.Background(), r, size, extractPath, opts)
+}
+
+// ExtractStream extracts the zip read sequentially from r, such as a pipe
+// or a network connection, into extractPath using the given options.
+// See StreamReader for what local headers cannot tell: entries whose size
+// is unknown fail with ErrUnknownSize. Entries are extracted one at a time,
+// Concurrency is ignored, and Started gets -1 for both totals.
+func ExtractStream(r io.Reader, extractPath string, opts ExtractOptions) (ExtractResult, error) {
+	destinationPath, err := checkDestination(extractPath)
+	if err != nil {
+		return ExtractResult{}, err
+	}
+	return extractTo(context.Background(), destinationPath, opts, func(e *extractor) error {
+		return e.runStream(NewStreamReader(r))
+	})
+}
+
+func extractFrom(ctx context.Context, ra io.ReaderAt, size int64, extractPath string, opts ExtractOptions) (ExtractResult, error) {
+	destinationPath, err := checkDestination(extractPath)
+	if err != nil {
+		return ExtractResult{}, err
+	}
+	r, err := zip.NewReader(ra, size)
+	if err != nil {
+		return ExtractResult{}, err
+	}
+	return extractTo(ctx, destinationPath, opts, entriesExtraction(newEntries(r.File)))
+}
+
+// checkDestination returns the trimmed extractPath, failing if its parent
+// directory does not exist.
+func checkDestination(extractPath string) (string, error) {
+	destinationPath := strings.TrimSpace(extractPath)
+	if destinationPath == "" {
+		return "", fmt.Errorf("destination is empty")
+	}
+	if !files.IsDir(dirname(destinationPath)) {
+		return "", fmt.Errorf("%s invalid path", destinationPath)
+	}
+	return destinationPath, nil
+}
+
+// extraction feeds the entries of an archive to an extractor.
+type extraction func(e *extractor) error
+
+// entriesExtraction extracts the entries selected by the options.
+func entriesExtraction(entries []*Entry) extraction {
+	return func(e *extractor) error {
+		return e.run(renameEntries(filterEntries(entries, e.opts.Filter), e.opts))
+	}
+}
+
+// extractTo runs x into destinationPath, directly or through a staging
+// directory.
+func extractTo(ctx context.Context, destinationPath string, opts ExtractOptions, x extraction) (ExtractResult, error) {
+	if opts.Atomic {
+		return extractAtomic(ctx, destinationPath, opts, x)
+	}
+	return extractFiles(ctx, destinationPath, opts, x)
+}
+
+// extractFiles runs x into destinationPath.
+func extractFiles(ctx context.Context, destinationPath string, opts ExtractOptions, x extraction) (ExtractResult, error) {
+	destinationBaseDir := filepath.ToSlash(destinationPath)
+	if err := os.MkdirAll(destinationBaseDir, 0755); err != nil {
+		return ExtractResult{}, err
+	}
+	fsys, err := NewDirFS(destinationBaseDir)
+	if err != nil {
+		return ExtractResult{}, err
+	}
+	return extractToFS(ctx, fsys, opts, x)
+}
+
+// ExtractToFS extracts contents of archivePath into fsys, such as a MemFS
+// or the directory returned by NewDirFS, with the given options.
+// Atomic and Replace are not supported.
+func ExtractToFS(ctx context.Context, archivePath string, fsys WritableFS, opts ExtractOptions) (ExtractResult, error) {
+	if opts.Atomic {
+		return ExtractResult{}, fmt.Errorf("atomic extraction not supported on file systems")
+	}
+	r, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return ExtractResult{}, err
+	}
+	defer r.Close()
+	return extractToFS(ctx, fsys, opts, entriesExtraction(newEntries(r.File)))
+}
+
+func extractToFS(ctx context.Context, fsys WritableFS, opts ExtractOptions, x extraction) (ExtractResult, error) {
+	e := &extractor{
+		ctx:      ctx,
+		opts:     opts,
+		fs:       fsys,
+		limiter:  &limiter{limits: opts.Limits},
+		progress: progressOrNoop(opts.Progress),
+	}
+	err := x(e)
+	return e.result, err
+}
+
+// renameEntries applies renameEntry to entries, dropping the ones mapped
+// to an empty path.
+func renameEntries(entries []*Entry, opts ExtractOptions) []*Entry {
+	if opts.StripComponents <= 0 && opts.Rewrite == nil {
+		return entries
+	}
+	var renamed []*Entry
+	for _, entry := range entries {
+		if r := renameEntry(entry, opts); r != nil {
+			renamed = append(renamed, r)
+		}
+	}
+	return renamed
+}
+
+// renameEntry applies StripComponents and Rewrite to the name of entry,
+// returning nil if it is mapped to an empty path.
+func renameEntry(entry *Entry, opts ExtractOptions) *Entry {
+	name := stripComponents(entry.Name, opts.StripComponents)
+	if opts.Rewrite != nil && name != "" {
+		name = opts.Rewrite(name)
+	}
+	if name == "" || name == "/" {
+		return nil
+	}
+	r := *entry
+	r.Name = name
+	return &r
+}
+
+// stripComponents removes the first n elements from the slash separated
+// name, returning an empty string if nothing is left.
+func stripComponents(name string, n int) string {
+	if n <= 0 {
+		return name
+	}
+	parts := strings.SplitN(strings.TrimLeft(name, "/"), "/", n+1)
+	if len(parts) <= n {
+		return ""
+	}
+	return parts[n]
+}
+
+// extractAtomic runs x into a staging directory, then moves it to
+// destinationPath.
+func extractAtomic(ctx context.Context, destinationPath string, opts ExtractOptions, x extraction) (ExtractResult, error) {
+	destinationPath = filepath.Clean(destinationPath)
+	if fi, err := os.Lstat(destinationPath); err == nil {
+		if !opts.Replace {
+			return ExtractResult{}, fmt.Errorf("%s: %w", destinationPath, ErrConflict)
+		}
+		if !fi.IsDir() {
+			return ExtractResult{}, fmt.Errorf("%s is not a directory", destinationPath)
+		}
+	}
+	staging, err := createTempDir(destinationPath)
+	if err != nil {
+		return ExtractResult{}, err
+	}
+	result, err := extractFiles(ctx, staging, opts, x)
+	if err == nil {
+		err = replaceDir(staging, destinationPath)
+	}
+	if err != nil {
+		os.RemoveAll(staging)
+	}
+	return result, err
+}
+
+// createTempDir creates a new hidden directory next to dirPath.
+func createTempDir(dirPath string) (string, error) {
+	for i := 0; ; i++ {
+		name := tempName(dirPath)
+		err := os.Mkdir(name, 0755)
+		if os.IsExist(err) && i < 100 {
+			continue
+		}
+		return name, err
+	}
+}
+
+// replaceDir renames staging to dirPath, moving aside and then removing
+// the directory already there, if any.
+func replaceDir(staging string, dirPath string) error {
+	if _, err := os.Lstat(dirPath); os.IsNotExist(err) {
+		return os.Rename(staging, dirPath)
+	}
+	old := tempName(dirPath)
+	if err := os.Rename(dirPath, old); err != nil {
+		return err
+	}
+	if err := os.Rename(staging, dirPath); err != nil {
+		if rerr := os.Rename(old, dirPath); rerr != nil {
+			return fmt.Errorf("%v, previous contents left in %s", err, old)
+		}
+		return err
+	}
+	return os.RemoveAll(old)
+}
+
+// extractor holds the state of a single extraction.
+type extractor struct {
+	ctx      context.Context
+	opts     ExtractOptions
+	fs       WritableFS
+	limiter  *limiter
+	progress Progress
+	// mu guards result, shared by the parallel workers.
+	mu     sync.Mutex
+	result ExtractResult
+	// dirs are the directory entries whose mode and time are applied once
+	// all the contents have been written.
+	dirs []extractedDir
+}
+
+type extractedDir struct {
+	path     string
+	mode     os.FileMode
+	modified time.Time
+}
+
+// run extracts entries, then applies the directories modes and times.
+func (e *extractor) run(entries []*Entry) error {
+	var size int64
+	for _, entry := range entries {
+		size += int64(entry.UncompressedSize)
+	}
+	e.progress.Started(len(entries), size)
+	var err error
+	if e.opts.Concurrency > 1 {
+		err = e.runParallel(entries)
+	} else {
+		err = e.runSequential(entries)
+	}
+	if err != nil {
+		return err
+	}
+	return e.finishDirs()
+}
+
+// runStream extracts the entries of sr as they are read.
+func (e *extractor) runStream(sr *StreamReader) error {
+	e.progress.Started(-1, -1)
+	for {
+		if err := e.ctx.Err(); err != nil {
+			return err
+		}
+		entry, err := sr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if e.opts.Filter != nil && !e.opts.Filter(entry) {
+			continue
+		}
+		if entry = renameEntry(entry, e.opts); entry == nil {
+			continue
+		}
+		destination, err := e.prepare(entry)
+		if err != nil {
+			return err
+		}
+		if err := e.extract(entry, destination); err != nil {
+			return err
+		}
+	}
+	return e.finishDirs()
+}
+
+func (e *extractor) runSequential(entries []*Entry) error {
+	for _, entry := range entries {
+		if err := e.ctx.Err(); err != nil {
+			return err
+		}
+		destination, err := e.prepare(entry)
+		if err != nil {
+			return err
+		}
+		if err := e.extract(entry, destination); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prepare checks entry against the limits and returns its destination, the
+// slash separated path in the file system, refusing paths outside of it.
+func (e *extractor) prepare(entry *Entry) (string, error) {
+	if err := e.limiter.entry(entry); err != nil {
+		return "", err
+	}
+	const root = "root"
+	destination := filepath.Join(root, filepath.FromSlash(entry.Name))
+	if !isInside(root, destination) {
+		return "", fmt.Errorf("illegal file path in archive: %s", entry.Name)
+	}
+	rel, err := filepath.Rel(root, destination)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// extract writes entry into destination, reporting to the configured Progress.
+func (e *extractor) extract(entry *Entry, destination string) error {
+	e.progress.EntryStarted(entry.Name, int64(entry.UncompressedSize))
+	err := e.extractEntry(entry, destination)
+	e.progress.EntryFinished(entry.Name, err)
+	return err
+}
+
+func (e *extractor) extractEntry(entry *Entry, destination string) error {
+	if entry.IsDir() {
+		return e.extractDir(entry, destination)
+	}
+	if err := e.mkdirAll(dirname(destination), 0755); err != nil {
+		return err
+	}
+	write, err := shouldOverwrite(e.fs, entry, destination, e.opts.Overwrite)
+	if err != nil {
+		return err
+	}
+	if !write {
+		e.skip(entry.Name)
+		return nil
+	}
+	if entry.Mode&os.ModeSymlink != 0 {
+		return e.extractSymlink(entry, destination)
+	}
+	return e.extractFile(entry, destination, entry.Mode.Perm()&^e.opts.Umask)
+}
+
+// skip records the entry name as skipped.
+func (e *extractor) skip(name string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.result.Skipped = append(e.result.Skipped, name)
+}
+
+// extractDir creates the directory for entry.
+// The directory is kept writable by the owner until finishDirs is called.
+func (e *extractor) extractDir(entry *Entry, destination string) error {
+	mode := entry.Mode.Perm() &^ e.opts.Umask
+	if err := e.mkdirAll(destination, mode|0700); err != nil {
+		return err
+	}
+	e.dirs = append(e.dirs, extractedDir{path: destination, mode: mode, modified: entry.Modified})
+	return nil
+}
+
+// mkdirAll creates dir, the file system refusing it when links already
+// extracted lead it outside of the destination directory.
+func (e *extractor) mkdirAll(dir string, perm os.FileMode) error {
+	return e.fs.MkdirAll(dir, perm)
+}
+
+// extractSymlink recreates the link entry, the file system refusing
+// targets that would point outside of the destination directory.
+func (e *extractor) extractSymlink(entry *Entry, destination string) error {
+	target, err := readLinkTarget(entry)
+	if err != nil {
+		return err
+	}
+	return e.fs.Symlink(target, destination)
+}
+
+// maxLinkTarget is the longest link target read from an archive.
+const maxLinkTarget = 4096
+
+func readLinkTarget(entry *Entry) (string, error) {
+	s, err := entry.Open()
+	if err != nil {
+		return "", err
+	}
+	defer s.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(s, maxLinkTarget+1))
+	if err != nil {
+		return "", err
+	}
+	if len(data) == 0 || len(data) > maxLinkTarget {
+		return "", fmt.Errorf("invalid link target in archive: %s", entry.Name)
+	}
+	return string(data), nil
+}
+
+// isInside reports whether the cleaned path is base or one of its descendants.
+func isInside(base string, path string) bool {
+	rel, err := filepath.Rel(base, filepath.Clean(path))
+	return err == nil && !strings.HasPrefix(rel, "..")
+}
+
+// finishDirs applies modes and modification times of the directory entries,
+// deepest first so that setting them does not touch the parents again.
+// Entries no longer real directories, e.g. replaced by links, are skipped.
+func (e *extractor) finishDirs() error {
+	for i := len(e.dirs) - 1; i >= 0; i-- {
+		d := e.dirs[i]
+		if fi, err := e.fs.Lstat(d.path); err != nil || !fi.IsDir() {
+			continue
+		}
+		if d.mode&0700 != 0700 {
+			if err := e.fs.Chmod(d.path, d.mode); err != nil {
+				return err
+			}
+		}
+		if err := e.setModTime(d.path, d.modified); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// shouldOverwrite applies the overwrite policy to destination, returning
+// true if entry has to be written.
+func shouldOverwrite(fsys WritableFS, entry *Entry, destination string, policy OverwritePolicy) (bool, error) {
+	fi, err := fsys.Lstat(destination)
+	if errors.Is(err, fs.ErrNotExist) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	switch policy {
+	case OverwriteAlways:
+		return true, nil
+	case OverwriteFail:
+		return false, fmt.Errorf("%s: %w", destination, ErrConflict)
+	case OverwriteIfNewer:
+		return entry.Modified.After(fi.ModTime()), nil
+	default:
+		return false, nil
+	}
+}
+
+// extractFile writes the contents of entry into destination, replacing any
+// existing file, then restores the entry modification time.
+func (e *extractor) extractFile(entry *Entry, destination string, perm os.FileMode) error {
+	s, err := entry.Open()
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	d, err := e.fs.Create(destination, perm)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(d, &contextReader{ctx: e.ctx, r: &progressReader{r: e.limiter.wrap(entry, s), p: e.progress}})
+	if cerr := d.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		if e.ctx.Err() != nil {
+			e.fs.Remove(destination)
+		}
+		return err
+	}
+	return e.setModTime(destination, entry.Modified)
+}
+
+// setModTime sets access and modification time of path to modified.
+// Entries without a modification time are left alone.
+func (e *extractor) setModTime(path string, modified time.Time) error {
+	if modified.IsZero() {
+		return nil
+	}
+	return e.fs.Chtimes(path, modified)
+}
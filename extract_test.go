@@ -0,0 +1,402 @@
+package zipext
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/enr/go-files/files"
+)
+
+type testEntry struct {
+	name     string
+	body     string
+	modified time.Time
+	mode     os.FileMode
+}
+
+func writeTestZip(t *testing.T, zipPath string, entries []testEntry) {
+	t.Helper()
+	zf, err := os.Create(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zf.Close()
+	zw := zip.NewWriter(zf)
+	for _, e := range entries {
+		h := &zip.FileHeader{Name: e.name, Method: zip.Deflate, Modified: e.modified}
+		if e.mode != 0 {
+			h.SetMode(e.mode)
+		}
+		w, err := zw.CreateHeader(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(e.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func assertFileContent(t *testing.T, path string, expected string) {
+	t.Helper()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("error reading %s: %v", path, err)
+	}
+	if string(data) != expected {
+		t.Errorf(`expected "%s" in %s but found "%s"`, expected, path, string(data))
+	}
+}
+
+type overwriteTest struct {
+	policy   OverwritePolicy
+	diskTime time.Time
+	expected string
+	skipped  int
+	conflict bool
+}
+
+func TestExtractWithOptionsOverwrite(t *testing.T) {
+	entryTime := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []overwriteTest{
+		{OverwriteSkip, entryTime, "old", 1, false},
+		{OverwriteAlways, entryTime, "new", 0, false},
+		{OverwriteFail, entryTime, "old", 0, true},
+		{OverwriteIfNewer, entryTime.Add(time.Hour), "old", 1, false},
+		{OverwriteIfNewer, entryTime.Add(-time.Hour), "new", 0, false},
+	}
+	dir := t.TempDir()
+	zipPath := filepath.Join(dir, "overwrite.zip")
+	writeTestZip(t, zipPath, []testEntry{{name: "a.txt", body: "new", modified: entryTime}, {name: "b.txt", body: "b", modified: entryTime}})
+	for i, tt := range tests {
+		destDir := filepath.Join(dir, "dest", string(rune('a'+i)))
+		createDir(destDir, t)
+		existing := filepath.Join(destDir, "a.txt")
+		if err := ioutil.WriteFile(existing, []byte("old"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(existing, tt.diskTime, tt.diskTime); err != nil {
+			t.Fatal(err)
+		}
+		result, err := ExtractWithOptions(zipPath, destDir, ExtractOptions{Overwrite: tt.policy})
+		if tt.conflict != errors.Is(err, ErrConflict) {
+			t.Errorf("policy %d: unexpected error %v", tt.policy, err)
+		}
+		if len(result.Skipped) != tt.skipped {
+			t.Errorf("policy %d: expected %d skipped but got %v", tt.policy, tt.skipped, result.Skipped)
+		}
+		assertFileContent(t, existing, tt.expected)
+	}
+}
+
+func TestExtractWithOptionsRestoresModeAndTime(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix permissions not supported")
+	}
+	modified := time.Date(2019, 6, 1, 10, 0, 0, 0, time.UTC)
+	dir := t.TempDir()
+	zipPath := filepath.Join(dir, "modes.zip")
+	zf, err := os.Create(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(zf)
+	for _, e := range []struct {
+		name string
+		mode os.FileMode
+	}{
+		{"bin/", os.ModeDir | 0555},
+		{"bin/run.sh", 0755},
+		{"bin/data.txt", 0644},
+	} {
+		h := &zip.FileHeader{Name: e.name, Method: zip.Deflate, Modified: modified}
+		h.SetMode(e.mode)
+		if _, err := zw.CreateHeader(h); err != nil {
+			t.Fatal(err)
+		}
+	}
+	zw.Close()
+	zf.Close()
+
+	destDir := filepath.Join(dir, "dest")
+	if _, err := ExtractWithOptions(zipPath, destDir, ExtractOptions{Umask: 0022}); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(filepath.Join(destDir, "bin"), 0755)
+	expected := map[string]os.FileMode{
+		"bin":          0555,
+		"bin/run.sh":   0755,
+		"bin/data.txt": 0644,
+	}
+	for name, mode := range expected {
+		fi, err := os.Stat(filepath.Join(destDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode().Perm() != mode {
+			t.Errorf("expected mode %v for %s but got %v", mode, name, fi.Mode().Perm())
+		}
+		if !fi.ModTime().Equal(modified) {
+			t.Errorf("expected mtime %v for %s but got %v", modified, name, fi.ModTime())
+		}
+	}
+
+	umaskDir := filepath.Join(dir, "umask")
+	if _, err := ExtractWithOptions(zipPath, umaskDir, ExtractOptions{Umask: 0077}); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(filepath.Join(umaskDir, "bin"), 0755)
+	fi, err := os.Stat(filepath.Join(umaskDir, "bin", "run.sh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0700 {
+		t.Errorf("expected mode 0700 with umask 0077 but got %v", fi.Mode().Perm())
+	}
+}
+
+func symlinkEntry(name string, target string) testEntry {
+	return testEntry{name: name, body: target, mode: os.ModeSymlink | 0777}
+}
+
+func TestExtractSymlinkEscape(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks require privileges")
+	}
+	tests := [][]testEntry{
+		{symlinkEntry("evil", "../../outside")},
+		{symlinkEntry("evil", "/etc")},
+		{symlinkEntry("sub/evil", "../..")},
+		{symlinkEntry("self", "."), symlinkEntry("self/evil", "..")},
+	}
+	dir := t.TempDir()
+	createDir(filepath.Join(dir, "dest"), t)
+	for i, entries := range tests {
+		zipPath := filepath.Join(dir, "links.zip")
+		writeTestZip(t, zipPath, entries)
+		destDir := filepath.Join(dir, "dest", string(rune('a'+i)))
+		if err := Extract(zipPath, destDir); err == nil {
+			t.Errorf("expected error extracting links %v", entries)
+		}
+		if _, err := os.Lstat(filepath.Join(destDir, "evil")); err == nil {
+			t.Errorf("escaping link %v was created", entries)
+		}
+	}
+}
+
+func TestExtractSymlinkChainEscape(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks require privileges")
+	}
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "secret"), "secret")
+	zipPath := filepath.Join(dir, "links.zip")
+	writeTestZip(t, zipPath, []testEntry{
+		symlinkEntry("c", "."),
+		symlinkEntry("b", "c/.."),
+		symlinkEntry("d", "b/secret"),
+	})
+	destDir := filepath.Join(dir, "dest")
+	if err := Extract(zipPath, destDir); err == nil {
+		t.Error("expected error extracting a chain of links leading outside")
+	}
+	if _, err := ioutil.ReadFile(filepath.Join(destDir, "d")); err == nil {
+		t.Error("file outside the destination readable through the links")
+	}
+}
+
+func TestExtractLinkReplacingDirectory(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks require privileges")
+	}
+	dir := t.TempDir()
+	zipPath := filepath.Join(dir, "links.zip")
+	writeTestZip(t, zipPath, []testEntry{
+		{name: "x/", mode: os.ModeDir | 0555},
+		symlinkEntry("c", "."),
+		symlinkEntry("x", "c"),
+	})
+	destDir := filepath.Join(dir, "dest")
+	_, err := ExtractWithOptions(zipPath, destDir, ExtractOptions{Overwrite: OverwriteAlways})
+	if err == nil {
+		t.Error("expected error replacing a directory with a link")
+	}
+	fi, err := os.Stat(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm()&0200 == 0 {
+		t.Errorf("mode of the parent of the destination changed to %v", fi.Mode().Perm())
+	}
+	fi, err = os.Lstat(filepath.Join(destDir, "x"))
+	if err != nil || !fi.IsDir() {
+		t.Errorf("expected x still a directory, got %v %v", fi, err)
+	}
+}
+
+func TestExtractSymlinkThroughExistingLink(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks require privileges")
+	}
+	dir := t.TempDir()
+	outside := filepath.Join(dir, "outside")
+	createDir(outside, t)
+	zipPath := filepath.Join(dir, "links.zip")
+	writeTestZip(t, zipPath, []testEntry{{name: "out/evil.txt", body: "evil"}})
+	destDir := filepath.Join(dir, "dest")
+	createDir(destDir, t)
+	if err := os.Symlink(outside, filepath.Join(destDir, "out")); err != nil {
+		t.Fatal(err)
+	}
+	if err := Extract(zipPath, destDir); err == nil {
+		t.Error("expected error writing through a link pointing outside of the destination")
+	}
+	if _, err := os.Lstat(filepath.Join(outside, "evil.txt")); err == nil {
+		t.Error("file was written outside the destination directory")
+	}
+}
+
+func TestExtractContextCancelled(t *testing.T) {
+	dir := t.TempDir()
+	zipPath := filepath.Join(dir, "cancel.zip")
+	writeTestZip(t, zipPath, []testEntry{{name: "a.txt", body: "a"}, {name: "b.txt", body: "b"}})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	destDir := filepath.Join(dir, "dest")
+	if _, err := ExtractContext(ctx, zipPath, destDir, ExtractOptions{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled but got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "a.txt")); !os.IsNotExist(err) {
+		t.Error("entry extracted after cancellation")
+	}
+}
+
+func TestExtractAtomic(t *testing.T) {
+	dir := t.TempDir()
+	zipPath := filepath.Join(dir, "atomic.zip")
+	writeTestZip(t, zipPath, []testEntry{{name: "a.txt", body: "a"}, {name: "sub/b.txt", body: "b"}})
+	dest := filepath.Join(dir, "dest")
+	if _, err := ExtractWithOptions(zipPath, dest, ExtractOptions{Atomic: true}); err != nil {
+		t.Fatal(err)
+	}
+	assertFileContent(t, filepath.Join(dest, "sub", "b.txt"), "b")
+
+	_, err := ExtractWithOptions(zipPath, dest, ExtractOptions{Atomic: true})
+	if !errors.Is(err, ErrConflict) {
+		t.Errorf("expected ErrConflict for existing destination but got %v", err)
+	}
+
+	writeTestFile(t, filepath.Join(dest, "stale.txt"), "stale")
+	if _, err := ExtractWithOptions(zipPath, dest, ExtractOptions{Atomic: true, Replace: true}); err != nil {
+		t.Fatal(err)
+	}
+	assertFileContent(t, filepath.Join(dest, "a.txt"), "a")
+	if files.Exists(filepath.Join(dest, "stale.txt")) {
+		t.Error("expected replaced destination without stale.txt")
+	}
+	assertNoTempFiles(t, dir)
+}
+
+func TestExtractAtomicFailureLeavesNothing(t *testing.T) {
+	dir := t.TempDir()
+	zipPath := filepath.Join(dir, "slip.zip")
+	writeTestZip(t, zipPath, []testEntry{{name: "a.txt", body: "a"}, {name: "../evil.txt", body: "evil"}})
+	dest := filepath.Join(dir, "dest")
+	if _, err := ExtractWithOptions(zipPath, dest, ExtractOptions{Atomic: true}); err == nil {
+		t.Fatal("expected error for zip slip entry")
+	}
+	if files.Exists(dest) {
+		t.Errorf("expected %s not created", dest)
+	}
+
+	writeTestFile(t, filepath.Join(dest, "keep.txt"), "keep")
+	if _, err := ExtractWithOptions(zipPath, dest, ExtractOptions{Atomic: true, Replace: true}); err == nil {
+		t.Fatal("expected error for zip slip entry")
+	}
+	assertFileContent(t, filepath.Join(dest, "keep.txt"), "keep")
+	if files.Exists(filepath.Join(dest, "a.txt")) {
+		t.Error("expected destination untouched")
+	}
+	assertNoTempFiles(t, dir)
+}
+
+func assertNoTempFiles(t *testing.T, dir string) {
+	t.Helper()
+	leftovers, err := filepath.Glob(filepath.Join(dir, ".*.tmp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leftovers) > 0 {
+		t.Errorf("expected temporary files removed but found %v", leftovers)
+	}
+}
+
+func TestExtractStripComponents(t *testing.T) {
+	dir := t.TempDir()
+	zipPath := filepath.Join(dir, "strip.zip")
+	writeTestZip(t, zipPath, []testEntry{
+		{name: "base/", mode: os.ModeDir | 0755},
+		{name: "base/a.txt", body: "a"},
+		{name: "base/sub/b.txt", body: "b"},
+		{name: "top.txt", body: "top"},
+	})
+	dest := filepath.Join(dir, "dest")
+	if _, err := ExtractWithOptions(zipPath, dest, ExtractOptions{StripComponents: 1}); err != nil {
+		t.Fatal(err)
+	}
+	assertFileContent(t, filepath.Join(dest, "a.txt"), "a")
+	assertFileContent(t, filepath.Join(dest, "sub", "b.txt"), "b")
+	if files.Exists(filepath.Join(dest, "top.txt")) || files.Exists(filepath.Join(dest, "base")) {
+		t.Error("expected entries with fewer components skipped")
+	}
+}
+
+func TestExtractRewrite(t *testing.T) {
+	dir := t.TempDir()
+	zipPath := filepath.Join(dir, "rewrite.zip")
+	writeTestZip(t, zipPath, []testEntry{
+		{name: "app-1.0/bin/run", body: "run"},
+		{name: "app-1.0/README", body: "readme"},
+	})
+	dest := filepath.Join(dir, "dest")
+	opts := ExtractOptions{
+		StripComponents: 1,
+		Rewrite: func(name string) string {
+			if name == "README" {
+				return ""
+			}
+			return "opt/" + name
+		},
+	}
+	if _, err := ExtractWithOptions(zipPath, dest, opts); err != nil {
+		t.Fatal(err)
+	}
+	assertFileContent(t, filepath.Join(dest, "opt", "bin", "run"), "run")
+	if files.Exists(filepath.Join(dest, "opt", "README")) {
+		t.Error("expected entry rewritten to empty path skipped")
+	}
+
+	opts = ExtractOptions{Rewrite: func(name string) string { return "../" + name }}
+	if _, err := ExtractWithOptions(zipPath, filepath.Join(dir, "escape"), opts); err == nil {
+		t.Error("expected error for rewritten path outside destination")
+	}
+}
+
+func TestExtractFromInvalidZip(t *testing.T) {
+	data := []byte("not a zip")
+	_, err := ExtractFrom(bytes.NewReader(data), int64(len(data)), filepath.Join(t.TempDir(), "dest"), ExtractOptions{})
+	if err == nil {
+		t.Error("expected error for invalid zip")
+	}
+}
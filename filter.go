@@ -0,0 +1,67 @@
+package zipext
+
+import (
+	"strings"
+)
+
+// EntryFilter selects the entries to extract, returning true for the ones
+// to keep.
+type EntryFilter func(entry *Entry) bool
+
+// NameFilter selects the entries with the given names. A name ending with
+// "/" selects the directory and everything under it.
+func NameFilter(names ...string) EntryFilter {
+	return func(entry *Entry) bool {
+		for _, name := range names {
+			if entry.Name == name || strings.HasSuffix(name, "/") && strings.HasPrefix(entry.Name, name) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// PatternFilter selects the entries matching the .gitignore style
+// patterns, see PatternSet. For example "WEB-INF/lib/" selects everything
+// under that directory and "*.xml" all the xml files.
+func PatternFilter(patterns ...string) (EntryFilter, error) {
+	ps, err := NewPatternSet(patterns)
+	if err != nil {
+		return nil, err
+	}
+	return func(entry *Entry) bool {
+		return ps.Match(entry.Name, strings.HasSuffix(entry.Name, "/"))
+	}, nil
+}
+
+// RegexpFilter selects the entries whose name matches any of the POSIX
+// regular expressions, as CreateExcluding does for exclusions.
+func RegexpFilter(exprs ...string) (EntryFilter, error) {
+	m, err := NewMatcher(exprs)
+	if err != nil {
+		return nil, err
+	}
+	return func(entry *Entry) bool {
+		return m.Match(entry.Name)
+	}, nil
+}
+
+// ExtractEntries extracts into extractPath only the entries of archivePath
+// selected by filter. Entries are checked and written as in Extract.
+func ExtractEntries(archivePath string, extractPath string, filter EntryFilter) (ExtractResult, error) {
+	return ExtractWithOptions(archivePath, extractPath, ExtractOptions{Filter: filter})
+}
+
+// filterEntries returns the entries selected by filter, all if it is nil.
+func filterEntries(entries []*Entry, filter EntryFilter) []*Entry {
+	if filter == nil {
+		return entries
+	}
+	var selected []*Entry
+	for _, entry := range entries {
+		if filter(entry) {
+			selected = append(selected, entry)
+		}
+	}
+	return selected
+}
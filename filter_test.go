@@ -0,0 +1,93 @@
+package zipext
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func extractedFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	var names []string
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			rel, err := filepath.Rel(dir, p)
+			if err != nil {
+				return err
+			}
+			names = append(names, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestExtractEntries(t *testing.T) {
+	dir := t.TempDir()
+	zipPath := filepath.Join(dir, "app.war")
+	writeTestZip(t, zipPath, []testEntry{
+		{name: "index.html", body: "index"},
+		{name: "WEB-INF/web.xml", body: "web"},
+		{name: "WEB-INF/lib/a.jar", body: "a"},
+		{name: "WEB-INF/lib/b.jar", body: "b"},
+		{name: "WEB-INF/classes/app.properties", body: "props"},
+	})
+	patterns, err := PatternFilter("WEB-INF/lib/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	regexps, err := RegexpFilter(`\.xml$`, `\.properties$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		filter   EntryFilter
+		expected string
+	}{
+		{NameFilter("WEB-INF/web.xml"), "WEB-INF/web.xml"},
+		{NameFilter("index.html", "WEB-INF/classes/"), "WEB-INF/classes/app.properties,index.html"},
+		{patterns, "WEB-INF/lib/a.jar,WEB-INF/lib/b.jar"},
+		{regexps, "WEB-INF/classes/app.properties,WEB-INF/web.xml"},
+		{func(e *Entry) bool { return e.UncompressedSize > 4 }, "WEB-INF/classes/app.properties,index.html"},
+	}
+	for i, tt := range tests {
+		dest := filepath.Join(dir, "dest"+strconv.Itoa(i))
+		if _, err := ExtractEntries(zipPath, dest, tt.filter); err != nil {
+			t.Fatal(err)
+		}
+		if actual := strings.Join(extractedFiles(t, dest), ","); actual != tt.expected {
+			t.Errorf("%d: expected %s but got %s", i, tt.expected, actual)
+		}
+	}
+}
+
+func TestExtractEntriesZipSlip(t *testing.T) {
+	dir := t.TempDir()
+	zipPath := filepath.Join(dir, "slip.zip")
+	writeTestZip(t, zipPath, []testEntry{{name: "a.txt", body: "a"}, {name: "../evil.txt", body: "evil"}})
+	if _, err := ExtractEntries(zipPath, filepath.Join(dir, "dest"), NameFilter("../evil.txt")); err == nil {
+		t.Fatal("expected error for zip slip entry")
+	}
+	if _, err := ExtractEntries(zipPath, filepath.Join(dir, "dest"), NameFilter("a.txt")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFilterInvalidPatterns(t *testing.T) {
+	if _, err := PatternFilter("[a"); err == nil {
+		t.Error("expected error for invalid pattern")
+	}
+	if _, err := RegexpFilter("["); err == nil {
+		t.Error("expected error for invalid regex")
+	}
+}
@@ -0,0 +1,415 @@
+package zipext
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WritableFS is a file system extraction can write to.
+// Names are slash separated paths relative to the root of the file system,
+// as in io/fs. Implementations used with ExtractOptions.Concurrency must be
+// safe for concurrent use.
+type WritableFS interface {
+	// MkdirAll creates the directory name and any missing parent.
+	MkdirAll(name string, perm fs.FileMode) error
+	// Create creates the file name, replacing the file or link already
+	// existing. Directories are not replaced.
+	Create(name string, perm fs.FileMode) (io.WriteCloser, error)
+	// Symlink creates name as a symbolic link to the slash separated target,
+	// replacing the file or link already existing. Directories are not
+	// replaced.
+	Symlink(target string, name string) error
+	// Lstat returns the info of name, without following links.
+	Lstat(name string) (fs.FileInfo, error)
+	// Remove removes the file name.
+	Remove(name string) error
+	// Chmod changes the permissions of name.
+	Chmod(name string, mode fs.FileMode) error
+	// Chtimes changes the modification time of name.
+	Chtimes(name string, modified time.Time) error
+}
+
+// dirFS is the WritableFS writing into a directory of the OS file system.
+type dirFS struct {
+	root string
+	// realRoot is root with symbolic links resolved.
+	realRoot string
+}
+
+// NewDirFS returns a WritableFS writing into the existing directory dir.
+// Symbolic links found on disk or extracted cannot lead files outside
+// of dir.
+func NewDirFS(dir string) (WritableFS, error) {
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return nil, err
+	}
+	return &dirFS{root: root, realRoot: realRoot}, nil
+}
+
+func (d *dirFS) path(name string) string {
+	return filepath.Join(d.root, filepath.FromSlash(name))
+}
+
+// MkdirAll creates name after checking that symbolic links already on disk
+// do not lead it outside of the root.
+func (d *dirFS) MkdirAll(name string, perm fs.FileMode) error {
+	dir := d.path(name)
+	existing := dir
+	for {
+		real, err := filepath.EvalSymlinks(existing)
+		if err == nil {
+			rest, _ := filepath.Rel(existing, dir)
+			if !isInside(d.realRoot, filepath.Join(real, rest)) {
+				return fmt.Errorf("illegal file path in archive: %s", name)
+			}
+			break
+		}
+		if !os.IsNotExist(err) {
+			return err
+		}
+		existing = filepath.Dir(existing)
+	}
+	return os.MkdirAll(dir, perm)
+}
+
+func (d *dirFS) Create(name string, perm fs.FileMode) (io.WriteCloser, error) {
+	if err := d.removeExisting(name); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(d.path(name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+}
+
+// Symlink creates the link refusing targets that would point outside of
+// the root.
+func (d *dirFS) Symlink(target string, name string) error {
+	target = filepath.FromSlash(target)
+	realDir, err := filepath.EvalSymlinks(filepath.Dir(d.path(name)))
+	if err != nil {
+		return err
+	}
+	if filepath.IsAbs(target) || filepath.VolumeName(target) != "" ||
+		strings.HasPrefix(target, string(filepath.Separator)) ||
+		!d.targetInside(realDir, target) {
+		return fmt.Errorf("illegal link target in archive: %s -> %s", name, target)
+	}
+	if err := d.removeExisting(name); err != nil {
+		return err
+	}
+	return os.Symlink(target, d.path(name))
+}
+
+// targetInside reports whether the relative target of a link in realDir
+// stays inside the root. ".." elements can only cross real directories,
+// which are never replaced, so that links extracted before or after this
+// one cannot move the target outside.
+func (d *dirFS) targetInside(realDir string, target string) bool {
+	parts := strings.Split(filepath.ToSlash(target), "/")
+	last := -1
+	for i, part := range parts {
+		if part == ".." {
+			last = i
+		}
+	}
+	cur := realDir
+	for i, part := range parts {
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			cur = filepath.Dir(cur)
+		default:
+			cur = filepath.Join(cur, part)
+		}
+		if !isInside(d.realRoot, cur) {
+			return false
+		}
+		if i < last {
+			fi, err := os.Lstat(cur)
+			if err != nil || !fi.IsDir() {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// removeExisting removes the file or link name, if any. Directories are
+// not replaced, as links checked through them could then lead elsewhere.
+func (d *dirFS) removeExisting(name string) error {
+	fi, err := os.Lstat(d.path(name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		return fmt.Errorf("%s: cannot replace a directory", name)
+	}
+	return os.Remove(d.path(name))
+}
+
+func (d *dirFS) Lstat(name string) (fs.FileInfo, error) {
+	return os.Lstat(d.path(name))
+}
+
+func (d *dirFS) Remove(name string) error {
+	return os.Remove(d.path(name))
+}
+
+func (d *dirFS) Chmod(name string, mode fs.FileMode) error {
+	return os.Chmod(d.path(name), mode)
+}
+
+func (d *dirFS) Chtimes(name string, modified time.Time) error {
+	return os.Chtimes(d.path(name), modified, modified)
+}
+
+// MemFS is an in-memory WritableFS, safe for concurrent use.
+// It is also an fs.FS, so that what was extracted can be read back.
+// Symbolic links are stored, but never followed while extracting.
+type MemFS struct {
+	mu    sync.Mutex
+	files map[string]*memEntry
+}
+
+// memEntry is a file, directory or link in a MemFS. Links hold their
+// target as data.
+type memEntry struct {
+	data    []byte
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (f *memEntry) info(name string) *fileInfo {
+	return &fileInfo{name: name, size: int64(len(f.data)), mode: f.mode, modTime: f.modTime}
+}
+
+// NewMemFS returns an empty MemFS.
+func NewMemFS() *MemFS {
+	return &MemFS{files: map[string]*memEntry{}}
+}
+
+// Open opens the file name for reading.
+func (m *MemFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if name == "." {
+		return &memDir{info: &fileInfo{name: ".", mode: fs.ModeDir | 0755}, entries: m.children(name)}, nil
+	}
+	f, ok := m.files[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if f.mode.IsDir() {
+		return &memDir{info: f.info(path.Base(name)), entries: m.children(name)}, nil
+	}
+	return &memOpenFile{info: f.info(path.Base(name)), r: bytes.NewReader(f.data)}, nil
+}
+
+// children lists the entries in the directory dir, sorted by name.
+func (m *MemFS) children(dir string) []fs.DirEntry {
+	var entries []fs.DirEntry
+	for name, f := range m.files {
+		if path.Dir(name) == dir {
+			entries = append(entries, fs.FileInfoToDirEntry(f.info(path.Base(name))))
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries
+}
+
+// MkdirAll creates the directory name and any missing parent.
+func (m *MemFS) MkdirAll(name string, perm fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for p := path.Clean(name); p != "." && p != "/"; p = path.Dir(p) {
+		f, ok := m.files[p]
+		if ok && !f.mode.IsDir() {
+			return &fs.PathError{Op: "mkdir", Path: p, Err: fs.ErrExist}
+		}
+		if !ok {
+			m.files[p] = &memEntry{mode: fs.ModeDir | perm, modTime: time.Now()}
+		}
+	}
+	return nil
+}
+
+// Create creates the file name, holding what is written once closed.
+func (m *MemFS) Create(name string, perm fs.FileMode) (io.WriteCloser, error) {
+	if err := m.checkParent("open", name); err != nil {
+		return nil, err
+	}
+	return &memFile{m: m, name: path.Clean(name), mode: perm}, nil
+}
+
+// Symlink stores name as a link to target.
+func (m *MemFS) Symlink(target string, name string) error {
+	if err := m.checkParent("symlink", name); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files[path.Clean(name)] = &memEntry{data: []byte(target), mode: fs.ModeSymlink | 0777, modTime: time.Now()}
+	return nil
+}
+
+// Lstat returns the info of name.
+func (m *MemFS) Lstat(name string) (fs.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, ok := m.files[path.Clean(name)]
+	if !ok {
+		return nil, &fs.PathError{Op: "lstat", Path: name, Err: fs.ErrNotExist}
+	}
+	return f.info(path.Base(name)), nil
+}
+
+// Remove removes name.
+func (m *MemFS) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.files[path.Clean(name)]; !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	delete(m.files, path.Clean(name))
+	return nil
+}
+
+// Chmod changes the permissions of name.
+func (m *MemFS) Chmod(name string, mode fs.FileMode) error {
+	return m.update("chmod", name, func(f *memEntry) {
+		f.mode = f.mode.Type() | mode.Perm()
+	})
+}
+
+// Chtimes changes the modification time of name.
+func (m *MemFS) Chtimes(name string, modified time.Time) error {
+	return m.update("chtimes", name, func(f *memEntry) {
+		f.modTime = modified
+	})
+}
+
+func (m *MemFS) update(op string, name string, fn func(f *memEntry)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, ok := m.files[path.Clean(name)]
+	if !ok {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	fn(f)
+	return nil
+}
+
+// checkParent fails if the parent of name is not a directory or if name is
+// a directory, which is never replaced.
+func (m *MemFS) checkParent(op string, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if f, ok := m.files[path.Clean(name)]; ok && f.mode.IsDir() {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrExist}
+	}
+	parent := path.Dir(path.Clean(name))
+	if parent == "." {
+		return nil
+	}
+	if f, ok := m.files[parent]; !ok || !f.mode.IsDir() {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return nil
+}
+
+// memFile buffers the contents of a file created in a MemFS.
+type memFile struct {
+	m    *MemFS
+	name string
+	mode fs.FileMode
+	buf  bytes.Buffer
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	return f.buf.Write(p)
+}
+
+func (f *memFile) Close() error {
+	f.m.mu.Lock()
+	defer f.m.mu.Unlock()
+	f.m.files[f.name] = &memEntry{data: f.buf.Bytes(), mode: f.mode, modTime: time.Now()}
+	return nil
+}
+
+// memOpenFile is a file of a MemFS opened for reading.
+type memOpenFile struct {
+	info *fileInfo
+	r    *bytes.Reader
+}
+
+func (f *memOpenFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *memOpenFile) Read(p []byte) (int, error) { return f.r.Read(p) }
+func (f *memOpenFile) Close() error               { return nil }
+
+// memDir is a directory of a MemFS opened for reading.
+type memDir struct {
+	info    *fileInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *memDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *memDir) Close() error               { return nil }
+
+func (d *memDir) Read(p []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: fs.ErrInvalid}
+}
+
+// ReadDir returns the next n entries, all the remaining ones if n <= 0.
+func (d *memDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(rest) {
+		n = len(rest)
+	}
+	d.offset += n
+	return rest[:n], nil
+}
+
+// fileInfo is the fs.FileInfo of files not coming from the OS, such as
+// the ones in a MemFS.
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (i *fileInfo) Name() string       { return i.name }
+func (i *fileInfo) Size() int64        { return i.size }
+func (i *fileInfo) Mode() fs.FileMode  { return i.mode }
+func (i *fileInfo) ModTime() time.Time { return i.modTime }
+func (i *fileInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i *fileInfo) Sys() interface{}   { return nil }
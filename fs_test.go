@@ -0,0 +1,123 @@
+package zipext
+
+import (
+	"bytes"
+	"context"
+	"io/fs"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestCreateFromFSExtractToFS(t *testing.T) {
+	modified := time.Date(2020, 5, 17, 10, 30, 0, 0, time.UTC)
+	src := fstest.MapFS{
+		"site/index.html":      {Data: []byte("index"), Mode: 0644, ModTime: modified},
+		"site/css/main.css":    {Data: []byte("body {}"), Mode: 0600, ModTime: modified},
+		"site/scripts/run.sh":  {Data: []byte("#!/bin/sh"), Mode: 0755, ModTime: modified},
+		"other/not-in-zip.txt": {Data: []byte("other")},
+	}
+	var buf bytes.Buffer
+	if err := CreateFromFS(src, "site", &buf); err != nil {
+		t.Fatal(err)
+	}
+	zipPath := filepath.Join(t.TempDir(), "site.zip")
+	if err := ioutil.WriteFile(zipPath, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	names := listEntries(t, zipPath)
+	expected := []string{"css/main.css", "index.html", "scripts/run.sh"}
+	if len(names) != len(expected) {
+		t.Fatalf("expected entries %v but got %v", expected, names)
+	}
+	for i, name := range expected {
+		if names[i] != name {
+			t.Errorf("expected entry %s but got %s", name, names[i])
+		}
+	}
+
+	dest := NewMemFS()
+	if _, err := ExtractToFS(context.Background(), zipPath, dest, ExtractOptions{Concurrency: 2}); err != nil {
+		t.Fatal(err)
+	}
+	for name, f := range src {
+		if name == "other/not-in-zip.txt" {
+			continue
+		}
+		rel := name[len("site/"):]
+		data, err := fs.ReadFile(dest, rel)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, f.Data) {
+			t.Errorf(`expected "%s" in %s but got "%s"`, f.Data, rel, data)
+		}
+		info, err := fs.Stat(dest, rel)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode() != f.Mode || !info.ModTime().Equal(modified) {
+			t.Errorf("%s: expected %v %v but got %v %v", rel, f.Mode, modified, info.Mode(), info.ModTime())
+		}
+	}
+}
+
+func TestExtractToFSZipSlip(t *testing.T) {
+	zipPath := filepath.Join(t.TempDir(), "slip.zip")
+	writeTestZip(t, zipPath, []testEntry{{name: "../evil.txt", body: "evil"}})
+	dest := NewMemFS()
+	if _, err := ExtractToFS(context.Background(), zipPath, dest, ExtractOptions{}); err == nil {
+		t.Fatal("expected error for zip slip entry")
+	}
+	if _, err := ExtractToFS(context.Background(), zipPath, dest, ExtractOptions{Atomic: true}); err == nil {
+		t.Error("expected error for atomic extraction")
+	}
+}
+
+func TestMemFSOverwrite(t *testing.T) {
+	zipPath := filepath.Join(t.TempDir(), "overwrite.zip")
+	writeTestZip(t, zipPath, []testEntry{{name: "dir/a.txt", body: "new"}, {name: "b.txt", body: "b"}})
+	dest := NewMemFS()
+	if err := dest.MkdirAll("dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	w, err := dest.Create("dir/a.txt", 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("old"))
+	w.Close()
+	result, err := ExtractToFS(context.Background(), zipPath, dest, ExtractOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Skipped) != 1 || result.Skipped[0] != "dir/a.txt" {
+		t.Errorf("expected dir/a.txt skipped but got %v", result.Skipped)
+	}
+	data, err := fs.ReadFile(dest, "dir/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "old" {
+		t.Errorf(`expected "old" but got "%s"`, data)
+	}
+}
+
+func TestMemFSIsFS(t *testing.T) {
+	zipPath := filepath.Join(t.TempDir(), "tree.zip")
+	writeTestZip(t, zipPath, []testEntry{
+		{name: "dir/"},
+		{name: "dir/a.txt", body: "a"},
+		{name: "dir/sub/b.txt", body: "b"},
+		{name: "c.txt", body: "c"},
+	})
+	dest := NewMemFS()
+	if _, err := ExtractToFS(context.Background(), zipPath, dest, ExtractOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fstest.TestFS(dest, "dir/a.txt", "dir/sub/b.txt", "c.txt"); err != nil {
+		t.Error(err)
+	}
+}
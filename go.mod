@@ -1,6 +1,6 @@
 module github.com/enr/zipext
 
-go 1.15
+go 1.17
 
 require (
 	github.com/enr/go-commons v0.0.0-20150504121636-bcd3f40eeea8
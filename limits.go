@@ -0,0 +1,98 @@
+package zipext
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Limits protects against zip bombs capping the data coming out of an
+// archive. Zero fields are not enforced.
+type Limits struct {
+	// MaxTotalSize is the maximum number of uncompressed bytes for the
+	// whole archive.
+	MaxTotalSize int64
+	// MaxEntrySize is the maximum number of uncompressed bytes for a single
+	// entry.
+	MaxEntrySize int64
+	// MaxEntries is the maximum number of entries, directories included.
+	MaxEntries int
+	// MaxRatio is the maximum ratio between uncompressed and compressed size
+	// of a single entry. It is not enforced on streamed entries whose
+	// compressed size comes after the data.
+	MaxRatio float64
+}
+
+// LimitError is the error returned when an archive crosses one of the
+// configured Limits.
+type LimitError struct {
+	// Limit is the name of the crossed limit, e.g. "MaxEntrySize".
+	Limit string
+	// Name is the name of the entry being read when the limit was crossed.
+	Name string
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s: limit %s exceeded", e.Name, e.Limit)
+}
+
+// limiter enforces Limits over all the entries of an archive.
+// It is safe for concurrent use.
+type limiter struct {
+	limits  Limits
+	mu      sync.Mutex
+	entries int
+	total   int64
+}
+
+// entry accounts for a new entry and checks the values declared in its
+// header. Declared sizes are checked again against the bytes actually read
+// by the reader returned from wrap.
+func (l *limiter) entry(entry *Entry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries++
+	if l.limits.MaxEntries > 0 && l.entries > l.limits.MaxEntries {
+		return &LimitError{Limit: "MaxEntries", Name: entry.Name}
+	}
+	return l.check(entry, int64(entry.UncompressedSize), l.total+int64(entry.UncompressedSize))
+}
+
+func (l *limiter) check(entry *Entry, size int64, total int64) error {
+	if l.limits.MaxEntrySize > 0 && size > l.limits.MaxEntrySize {
+		return &LimitError{Limit: "MaxEntrySize", Name: entry.Name}
+	}
+	if l.limits.MaxTotalSize > 0 && total > l.limits.MaxTotalSize {
+		return &LimitError{Limit: "MaxTotalSize", Name: entry.Name}
+	}
+	if l.limits.MaxRatio > 0 && entry.CompressedSize > 0 && float64(size) > l.limits.MaxRatio*float64(entry.CompressedSize) {
+		return &LimitError{Limit: "MaxRatio", Name: entry.Name}
+	}
+	return nil
+}
+
+// wrap returns a reader failing with a LimitError as soon as the bytes read
+// from r cross a limit.
+func (l *limiter) wrap(entry *Entry, r io.Reader) io.Reader {
+	return &limitedReader{r: r, entry: entry, l: l}
+}
+
+type limitedReader struct {
+	r     io.Reader
+	entry *Entry
+	l     *limiter
+	read  int64
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	n, err := lr.r.Read(p)
+	lr.read += int64(n)
+	lr.l.mu.Lock()
+	lr.l.total += int64(n)
+	lerr := lr.l.check(lr.entry, lr.read, lr.l.total)
+	lr.l.mu.Unlock()
+	if lerr != nil {
+		return n, lerr
+	}
+	return n, err
+}
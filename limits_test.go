@@ -0,0 +1,92 @@
+package zipext
+
+import (
+	"archive/zip"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExtractWithLimits(t *testing.T) {
+	dir := t.TempDir()
+	zipPath := filepath.Join(dir, "bomb.zip")
+	writeTestZip(t, zipPath, []testEntry{
+		{name: "a.txt", body: "small"},
+		{name: "zeros.txt", body: strings.Repeat("0", 1<<20)},
+		{name: "b.txt", body: "small"},
+	})
+	tests := []struct {
+		limits   Limits
+		expected string
+	}{
+		{Limits{MaxEntries: 2}, "MaxEntries"},
+		{Limits{MaxEntrySize: 1024}, "MaxEntrySize"},
+		{Limits{MaxTotalSize: 1 << 20}, "MaxTotalSize"},
+		{Limits{MaxRatio: 100}, "MaxRatio"},
+		{Limits{MaxEntries: 3, MaxEntrySize: 1 << 20, MaxTotalSize: 1<<20 + 10, MaxRatio: 2000}, ""},
+	}
+	for i, tt := range tests {
+		destDir := filepath.Join(dir, string(rune('a'+i)))
+		_, err := ExtractWithOptions(zipPath, destDir, ExtractOptions{Limits: tt.limits})
+		if tt.expected == "" {
+			if err != nil {
+				t.Errorf("limits %+v: unexpected error %v", tt.limits, err)
+			}
+			continue
+		}
+		var limitErr *LimitError
+		if !errors.As(err, &limitErr) {
+			t.Errorf("limits %+v: expected LimitError but got %v", tt.limits, err)
+			continue
+		}
+		if limitErr.Limit != tt.expected {
+			t.Errorf("expected limit %s but got %s", tt.expected, limitErr.Limit)
+		}
+	}
+}
+
+func TestLimitedReaderCountsBytesRead(t *testing.T) {
+	l := &limiter{limits: Limits{MaxEntrySize: 10}}
+	f := &Entry{Name: "lying.txt", UncompressedSize: 1}
+	if err := l.entry(f); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	r := l.wrap(f, strings.NewReader(strings.Repeat("x", 100)))
+	var err error
+	read := 0
+	for err == nil {
+		var n int
+		n, err = r.Read(buf)
+		read += n
+	}
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) || limitErr.Limit != "MaxEntrySize" {
+		t.Errorf("expected MaxEntrySize LimitError but got %v", err)
+	}
+	if read > 12 {
+		t.Errorf("reader went on after the limit, read %d bytes", read)
+	}
+}
+
+func TestWalkWithLimits(t *testing.T) {
+	dir := t.TempDir()
+	zipPath := filepath.Join(dir, "entries.zip")
+	writeTestZip(t, zipPath, []testEntry{{name: "a.txt"}, {name: "b.txt"}, {name: "c.txt"}})
+	visited := 0
+	err := WalkWithLimits(zipPath, Limits{MaxEntries: 2}, func(f *zip.File, err error) error {
+		if err != nil {
+			return err
+		}
+		visited++
+		return nil
+	})
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) || limitErr.Name != "c.txt" {
+		t.Errorf("expected LimitError on c.txt but got %v", err)
+	}
+	if visited != 2 {
+		t.Errorf("expected 2 visited entries but got %d", visited)
+	}
+}
@@ -0,0 +1,260 @@
+package zipext
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"strings"
+	"sync"
+)
+
+// compressed is the outcome of compressing an entry in a worker.
+type compressed struct {
+	header *zip.FileHeader
+	// data is nil for directories and broken links skipped.
+	data *spooled
+	err  error
+}
+
+func (c compressed) close() {
+	if c.data != nil {
+		c.data.data.Close()
+	}
+}
+
+// writeParallel compresses the entries with cfg.concurrency workers and
+// writes them in order through zip.Writer.CreateRaw.
+// At most twice the number of workers entries are kept compressed waiting
+// to be written.
+func writeParallel(ctx context.Context, zw *zip.Writer, entries []createEntry, cfg createConfig) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	cfg.progress = &syncProgress{p: cfg.progress}
+	results := make([]chan compressed, len(entries))
+	for i := range results {
+		results[i] = make(chan compressed, 1)
+	}
+	jobs := make(chan int)
+	window := make(chan struct{}, 2*cfg.concurrency)
+	var wg sync.WaitGroup
+	for w := 0; w < cfg.concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] <- compressEntry(ctx, entries[i], cfg)
+			}
+		}()
+	}
+	go func() {
+		defer close(jobs)
+		for i := range entries {
+			select {
+			case window <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			jobs <- i
+		}
+	}()
+	err := writeCompressed(ctx, zw, entries, results, window, cfg)
+	cancel()
+	wg.Wait()
+	for _, r := range results {
+		select {
+		case c := <-r:
+			c.close()
+		default:
+		}
+	}
+	return err
+}
+
+// writeCompressed writes the entries in order, waiting for the workers.
+func writeCompressed(ctx context.Context, zw *zip.Writer, entries []createEntry, results []chan compressed, window chan struct{}, cfg createConfig) error {
+	p := cfg.progress
+	for i, e := range entries {
+		var c compressed
+		select {
+		case c = <-results[i]:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		<-window
+		p.EntryStarted(e.internalPath, e.size())
+		err := c.err
+		if err == nil {
+			err = writeCompressedEntry(ctx, zw, e, c, cfg)
+		}
+		c.close()
+		p.EntryFinished(e.internalPath, err)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeCompressedEntry(ctx context.Context, zw *zip.Writer, e createEntry, c compressed, cfg createConfig) error {
+	if c.data == nil {
+		if c.header == nil {
+			return nil
+		}
+		_, err := zw.CreateHeader(c.header)
+		return err
+	}
+	if c.data.method != zip.Deflate || c.data.compressedSize < c.data.size || e.compression == nil || !e.compression.StoreIfLarger {
+		return c.data.writeRaw(zw, c.header)
+	}
+	return c.data.writeStored(ctx, zw, c.header)
+}
+
+// compressEntry spools the data of e, deflated or stored, with its checksum,
+// so that every entry but directories is written raw, without data
+// descriptors.
+func compressEntry(ctx context.Context, e createEntry, cfg createConfig) compressed {
+	header, err := newHeader(e, cfg)
+	if err != nil {
+		return compressed{err: err}
+	}
+	if e.info.IsDir() {
+		return compressed{header: header}
+	}
+	fr, err := e.contents()
+	if err != nil {
+		if !e.storeSymlinks && e.isBrokenLink() {
+			// broken links are ignored
+			return compressed{}
+		}
+		return compressed{err: err}
+	}
+	defer fr.Close()
+	var d *spooled
+	if header.Method == zip.Deflate {
+		d, err = deflate(ctx, fr, deflateLevel(e.compression), cfg.progress)
+	} else {
+		d, err = store(ctx, fr, cfg.progress)
+	}
+	return compressed{header: header, data: d, err: err}
+}
+
+// runParallel extracts entries with e.opts.Concurrency workers.
+// All the entries are checked and directories and links are created before
+// starting the workers, which write the regular files.
+func (e *extractor) runParallel(entries []*Entry) error {
+	e.progress = &syncProgress{p: e.progress}
+	destinations := make([]string, len(entries))
+	for i, entry := range entries {
+		destination, err := e.prepare(entry)
+		if err != nil {
+			return err
+		}
+		destinations[i] = destination
+	}
+	var jobs []int
+	for i, entry := range entries {
+		if err := e.ctx.Err(); err != nil {
+			return err
+		}
+		if !entry.Mode.IsRegular() {
+			if err := e.extract(entry, destinations[i]); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := e.mkdirAll(dirname(destinations[i]), 0755); err != nil {
+			return err
+		}
+		jobs = append(jobs, i)
+	}
+	errs := runWorkers(e.ctx, e.opts.Concurrency, len(jobs), func(j int) error {
+		i := jobs[j]
+		return e.extract(entries[i], destinations[i])
+	})
+	return joinErrors(errs)
+}
+
+// runWorkers calls work for every index in [0, n) from the given number of
+// goroutines. No work is started after the first error or once ctx is done.
+// The errors of all the calls are returned.
+func runWorkers(ctx context.Context, workers int, n int, work func(i int) error) []error {
+	var mu sync.Mutex
+	var errs []error
+	failed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(errs) > 0
+	}
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if err := work(i); err != nil {
+					mu.Lock()
+					errs = append(errs, err)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for i := 0; i < n && !failed() && ctx.Err() == nil; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	if len(errs) == 0 && ctx.Err() != nil {
+		errs = append(errs, ctx.Err())
+	}
+	return errs
+}
+
+// MultiError collects the errors of entries processed in parallel.
+type MultiError []error
+
+func (m MultiError) Error() string {
+	msgs := make([]string, len(m))
+	for i, err := range m {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Unwrap returns the collected errors.
+func (m MultiError) Unwrap() []error {
+	return m
+}
+
+// Is reports whether any of the collected errors matches target.
+func (m MultiError) Is(target error) bool {
+	for _, err := range m {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first collected error that matches target.
+func (m MultiError) As(target interface{}) bool {
+	for _, err := range m {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// joinErrors returns nil, the only error or a MultiError.
+func joinErrors(errs []error) error {
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	default:
+		return MultiError(errs)
+	}
+}
@@ -0,0 +1,147 @@
+package zipext
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCreateWithOptionsConcurrency(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	for i := 0; i < 40; i++ {
+		writeTestFile(t, filepath.Join(src, fmt.Sprintf("d%d", i%4), fmt.Sprintf("f%02d.txt", i)), strings.Repeat(fmt.Sprintf("file %d ", i), 100*i))
+	}
+	writeTestFile(t, filepath.Join(src, "image.png"), "not really a png")
+	sequential := filepath.Join(dir, "sequential.zip")
+	if err := CreateWithOptions(src, sequential, CreateOptions{Reproducible: true, Directories: true}); err != nil {
+		t.Fatal(err)
+	}
+	parallel := filepath.Join(dir, "parallel.zip")
+	progress := &recordingProgress{}
+	opts := CreateOptions{
+		Reproducible: true,
+		Directories:  true,
+		Concurrency:  4,
+		Progress:     progress,
+		Compression: &Compression{
+			Level:           CompressionLevel(flate.BestSpeed),
+			StoreExtensions: CompressedExtensions,
+			StoreIfLarger:   true,
+		},
+	}
+	if err := CreateWithOptions(src, parallel, opts); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(listEntries(t, sequential), listEntries(t, parallel)) {
+		t.Errorf("different entries order: %v %v", listEntries(t, sequential), listEntries(t, parallel))
+	}
+	if len(progress.started) != len(listEntries(t, parallel)) || progress.copied != progress.bytes {
+		t.Errorf("unexpected progress %d entries %d/%d bytes", len(progress.started), progress.copied, progress.bytes)
+	}
+
+	destDir := filepath.Join(dir, "dest")
+	if err := Extract(parallel, destDir); err != nil {
+		t.Fatal(err)
+	}
+	assertFileContent(t, filepath.Join(destDir, "src", "d3", "f39.txt"), strings.Repeat("file 39 ", 3900))
+	assertFileContent(t, filepath.Join(destDir, "src", "image.png"), "not really a png")
+}
+
+func TestCreateConcurrencyReproducible(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "src")
+	for i := 0; i < 20; i++ {
+		writeTestFile(t, filepath.Join(src, fmt.Sprintf("d%d", i%3), fmt.Sprintf("f%02d.txt", i)), strings.Repeat(fmt.Sprintf("file %d ", i), 50*i))
+	}
+	writeTestFile(t, filepath.Join(src, "image.png"), "not really a png")
+	writeTestFile(t, filepath.Join(src, "nome è utf8.txt"), "x")
+	for _, c := range []*Compression{nil, {Level: CompressionLevel(flate.BestSpeed), StoreExtensions: CompressedExtensions, StoreIfLarger: true}} {
+		var zips [2]bytes.Buffer
+		for i, concurrency := range []int{1, 4} {
+			opts := CreateOptions{Reproducible: true, Directories: true, Compression: c, Concurrency: concurrency}
+			if err := CreateTo(&zips[i], src, opts); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if !bytes.Equal(zips[0].Bytes(), zips[1].Bytes()) {
+			t.Errorf("compression %+v: zips differ with concurrency 1 and 4 (%d and %d bytes)", c, zips[0].Len(), zips[1].Len())
+		}
+	}
+}
+
+func listEntries(t *testing.T, zipPath string) []string {
+	t.Helper()
+	names := []string{}
+	err := WalkEntries(zipPath, func(e *Entry, err error) error {
+		if err != nil {
+			return err
+		}
+		names = append(names, e.Name)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func TestExtractWithOptionsConcurrency(t *testing.T) {
+	dir := t.TempDir()
+	entries := []testEntry{{name: "dir/"}, {name: "empty/"}}
+	for i := 0; i < 30; i++ {
+		entries = append(entries, testEntry{name: fmt.Sprintf("dir/sub%d/f%02d.txt", i%3, i), body: strings.Repeat("x", i*100)})
+	}
+	zipPath := filepath.Join(dir, "parallel.zip")
+	writeTestZip(t, zipPath, entries)
+
+	destDir := filepath.Join(dir, "dest")
+	progress := &recordingProgress{}
+	opts := ExtractOptions{Concurrency: 4, Progress: progress}
+	if _, err := ExtractWithOptions(zipPath, destDir, opts); err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries[2:] {
+		assertFileContent(t, filepath.Join(destDir, filepath.FromSlash(e.name)), e.body)
+	}
+	progress.verify(t, len(entries), progress.bytes)
+
+	opts = ExtractOptions{Concurrency: 4, Overwrite: OverwriteFail}
+	_, err := ExtractWithOptions(zipPath, destDir, opts)
+	if !errors.Is(err, ErrConflict) {
+		t.Errorf("expected ErrConflict from workers but got %v", err)
+	}
+}
+
+func TestExtractWithOptionsConcurrencyChecksPathsFirst(t *testing.T) {
+	dir := t.TempDir()
+	zipPath := filepath.Join(dir, "slip.zip")
+	writeTestZip(t, zipPath, []testEntry{{name: "a.txt", body: "a"}, {name: "../evil.txt", body: "evil"}})
+	destDir := filepath.Join(dir, "dest")
+	if _, err := ExtractWithOptions(zipPath, destDir, ExtractOptions{Concurrency: 2}); err == nil {
+		t.Error("expected error for zip slip path")
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "a.txt")); !os.IsNotExist(err) {
+		t.Error("entries written before checking all the paths")
+	}
+}
+
+func TestMultiError(t *testing.T) {
+	limitErr := &LimitError{Limit: "MaxEntries", Name: "a"}
+	err := joinErrors([]error{fmt.Errorf("first"), limitErr})
+	var target *LimitError
+	if !errors.As(err, &target) || target != limitErr {
+		t.Errorf("expected LimitError found in %v", err)
+	}
+	if err.Error() != "first; a: limit MaxEntries exceeded" {
+		t.Errorf("unexpected message %s", err.Error())
+	}
+	if joinErrors(nil) != nil {
+		t.Error("expected nil joining no errors")
+	}
+}
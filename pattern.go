@@ -0,0 +1,221 @@
+package zipext
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// PatternSet is a list of glob patterns using the .gitignore syntax:
+//
+//   - "*" matches anything but "/", "?" any single character but "/" and
+//     "[...]" a character class;
+//   - "**" matches any number of directories, as in "**/*.class",
+//     "logs/**" or "a/**/b";
+//   - a pattern without a "/", apart from a trailing one, matches at any
+//     depth, otherwise it is relative to the root;
+//   - a trailing "/" matches only directories;
+//   - a leading "!" re-includes what a previous pattern matched;
+//   - blank lines and lines starting with "#" are ignored.
+//
+// As in git, the last matching pattern wins and a path is matched when any
+// of its parent directories is.
+type PatternSet struct {
+	patterns []*globPattern
+}
+
+type globPattern struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+	// base is the slash terminated directory the pattern is relative to,
+	// empty for the root.
+	base string
+}
+
+// NewPatternSet compiles patterns, returning an error naming the first
+// invalid one.
+func NewPatternSet(patterns []string) (*PatternSet, error) {
+	return (*PatternSet)(nil).extend(patterns, "")
+}
+
+// Match reports whether the slash separated path, relative to the root of
+// the set, is matched. isDir tells if path is a directory.
+func (ps *PatternSet) Match(path string, isDir bool) bool {
+	if ps == nil || len(ps.patterns) == 0 {
+		return false
+	}
+	path = strings.Trim(path, "/")
+	for i := strings.Index(path, "/"); i != -1; {
+		if ps.matchPath(path[:i], true) {
+			return true
+		}
+		next := strings.Index(path[i+1:], "/")
+		if next == -1 {
+			break
+		}
+		i += next + 1
+	}
+	return ps.matchPath(path, isDir)
+}
+
+// matchPath applies the patterns to path only, ignoring its parents.
+func (ps *PatternSet) matchPath(path string, isDir bool) bool {
+	if ps == nil {
+		return false
+	}
+	matched := false
+	for _, p := range ps.patterns {
+		if p.dirOnly && !isDir {
+			continue
+		}
+		if !strings.HasPrefix(path, p.base) {
+			continue
+		}
+		if p.re.MatchString(strings.TrimPrefix(path, p.base)) {
+			matched = !p.negate
+		}
+	}
+	return matched
+}
+
+// extend returns a new set with ps patterns followed by patterns, which
+// are relative to the directory base.
+func (ps *PatternSet) extend(patterns []string, base string) (*PatternSet, error) {
+	extended := &PatternSet{}
+	if ps != nil {
+		extended.patterns = append(extended.patterns, ps.patterns...)
+	}
+	if base != "" {
+		base = strings.Trim(base, "/") + "/"
+	}
+	for _, line := range patterns {
+		p, err := parsePattern(line, base)
+		if err != nil {
+			return nil, err
+		}
+		if p != nil {
+			extended.patterns = append(extended.patterns, p)
+		}
+	}
+	return extended, nil
+}
+
+// extendFromFile adds the patterns read from the ignore file at filePath.
+func (ps *PatternSet) extendFromFile(filePath string, base string) (*PatternSet, error) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	extended, err := ps.extend(lines, base)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filePath, err)
+	}
+	return extended, nil
+}
+
+// parsePattern compiles a line, returning nil for blank lines and comments.
+func parsePattern(line string, base string) (*globPattern, error) {
+	p := &globPattern{base: base}
+	glob := strings.TrimRight(line, " ")
+	if strings.HasSuffix(line, "\\ ") {
+		glob += " "
+	}
+	if glob == "" || strings.HasPrefix(glob, "#") {
+		return nil, nil
+	}
+	if strings.HasPrefix(glob, "!") {
+		p.negate = true
+		glob = glob[1:]
+	}
+	if strings.HasSuffix(glob, "/") {
+		p.dirOnly = true
+		glob = strings.TrimRight(glob, "/")
+	}
+	anchored := strings.Contains(glob, "/")
+	glob = strings.TrimPrefix(glob, "/")
+	if glob == "" {
+		return nil, fmt.Errorf("invalid pattern %q", line)
+	}
+	expr, err := globToRegexp(glob)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %v", line, err)
+	}
+	if !anchored {
+		expr = "(?:.*/)?" + expr
+	}
+	p.re, err = regexp.Compile("^" + expr + "$")
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %v", line, err)
+	}
+	return p, nil
+}
+
+// globToRegexp translates a glob to a regular expression.
+func globToRegexp(glob string) (string, error) {
+	var sb strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/") && (i == 0 || glob[i-1] == '/'):
+			sb.WriteString("(?:.*/)?")
+			i += 2
+		case glob[i:] == "**" && i > 0 && glob[i-1] == '/':
+			sb.WriteString(".+")
+			i++
+		case c == '*':
+			sb.WriteString("[^/]*")
+			for i+1 < len(glob) && glob[i+1] == '*' {
+				i++
+			}
+		case c == '?':
+			sb.WriteString("[^/]")
+		case c == '[':
+			end, class, err := charClass(glob, i)
+			if err != nil {
+				return "", err
+			}
+			sb.WriteString(class)
+			i = end
+		case c == '\\' && i+1 < len(glob):
+			i++
+			sb.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		default:
+			sb.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	return sb.String(), nil
+}
+
+// charClass translates the character class starting at glob[start],
+// returning the index of its closing bracket.
+func charClass(glob string, start int) (int, string, error) {
+	i := start + 1
+	var sb strings.Builder
+	sb.WriteString("[")
+	if i < len(glob) && (glob[i] == '!' || glob[i] == '^') {
+		sb.WriteString("^")
+		i++
+	}
+	first := i
+	for ; i < len(glob); i++ {
+		c := glob[i]
+		if c == ']' && i > first {
+			sb.WriteString("]")
+			return i, sb.String(), nil
+		}
+		if c == '\\' || c == '[' || c == ']' {
+			sb.WriteString("\\")
+		}
+		sb.WriteByte(c)
+	}
+	return 0, "", fmt.Errorf("unterminated character class")
+}
+
+// relativePath returns the slash separated path of p relative to base.
+func relativePath(base string, p string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(path.Clean(p), path.Clean(base)), "/")
+}
@@ -0,0 +1,110 @@
+package zipext
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestPatternSetMatch(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		path     string
+		isDir    bool
+		expected bool
+	}{
+		{[]string{"*.class"}, "Main.class", false, true},
+		{[]string{"*.class"}, "a/b/Main.class", false, true},
+		{[]string{"*.class"}, "Main.java", false, false},
+		{[]string{"**/*.class"}, "a/b/Main.class", false, true},
+		{[]string{"**/*.class"}, "Main.class", false, true},
+		{[]string{"target/"}, "target", true, true},
+		{[]string{"target/"}, "target", false, false},
+		{[]string{"target/"}, "target/classes/Main.class", false, true},
+		{[]string{"target/"}, "sub/target/x", false, true},
+		{[]string{"target/"}, "targettest/x", false, false},
+		{[]string{"/target"}, "sub/target", true, false},
+		{[]string{"/target"}, "target/x", false, true},
+		{[]string{"doc/*.txt"}, "doc/a.txt", false, true},
+		{[]string{"doc/*.txt"}, "doc/sub/a.txt", false, false},
+		{[]string{"a/**/b"}, "a/b", false, true},
+		{[]string{"a/**/b"}, "a/x/y/b", false, true},
+		{[]string{"logs/**"}, "logs/2020/app.log", false, true},
+		{[]string{"logs/**"}, "logs", true, false},
+		{[]string{"file?.txt"}, "file1.txt", false, true},
+		{[]string{"file?.txt"}, "file10.txt", false, false},
+		{[]string{"file[0-9].txt"}, "file7.txt", false, true},
+		{[]string{"file[!0-9].txt"}, "file7.txt", false, false},
+		{[]string{"*.log", "!keep.log"}, "keep.log", false, false},
+		{[]string{"*.log", "!keep.log"}, "drop.log", false, true},
+		{[]string{"build/", "!build/keep.txt"}, "build/keep.txt", false, true},
+		{[]string{"# comment", "", "\\#hash"}, "#hash", false, true},
+		{[]string{"# comment"}, "# comment", false, false},
+	}
+	for _, tt := range tests {
+		ps, err := NewPatternSet(tt.patterns)
+		if err != nil {
+			t.Fatalf("%v: %v", tt.patterns, err)
+		}
+		if actual := ps.Match(tt.path, tt.isDir); actual != tt.expected {
+			t.Errorf("%v match %s (dir %t): expected %t but got %t", tt.patterns, tt.path, tt.isDir, tt.expected, actual)
+		}
+	}
+}
+
+func TestNewPatternSetInvalid(t *testing.T) {
+	_, err := NewPatternSet([]string{"*.txt", "file[0-9.txt"})
+	if err == nil {
+		t.Fatal("expected error for unterminated character class")
+	}
+	if !strings.Contains(err.Error(), "file[0-9.txt") {
+		t.Errorf("expected error naming the pattern but got %v", err)
+	}
+}
+
+func TestCreateWithOptionsPatterns(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	writeTestFile(t, filepath.Join(src, ".zipignore"), "*.tmp\n/dist/\n")
+	writeTestFile(t, filepath.Join(src, "main.go"), "main")
+	writeTestFile(t, filepath.Join(src, "notes.txt"), "notes")
+	writeTestFile(t, filepath.Join(src, "cache.tmp"), "tmp")
+	writeTestFile(t, filepath.Join(src, "dist", "app.go"), "dist")
+	writeTestFile(t, filepath.Join(src, "target", "gen.go"), "gen")
+	writeTestFile(t, filepath.Join(src, "targettest", "gen_test.go"), "test")
+	writeTestFile(t, filepath.Join(src, "pkg", ".zipignore"), "*.go\n!keep.go\n")
+	writeTestFile(t, filepath.Join(src, "pkg", "drop.go"), "drop")
+	writeTestFile(t, filepath.Join(src, "pkg", "keep.go"), "keep")
+	writeTestFile(t, filepath.Join(src, "pkg", "sub", "deep.go"), "deep")
+
+	zipPath := filepath.Join(dir, "patterns.zip")
+	opts := CreateOptions{
+		ExcludePatterns: []string{"target/"},
+		IncludePatterns: []string{"*.go"},
+		IgnoreFiles:     []string{".zipignore"},
+	}
+	if err := CreateWithOptions(src, zipPath, opts); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for name := range zipEntries(t, zipPath) {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	expected := []string{"src/main.go", "src/pkg/keep.go", "src/targettest/gen_test.go"}
+	if strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected entries %v but got %v", expected, names)
+	}
+}
+
+func TestCreateWithOptionsInvalidPattern(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	writeTestFile(t, filepath.Join(src, "a.txt"), "a")
+	zipPath := filepath.Join(dir, "invalid.zip")
+	err := CreateWithOptions(src, zipPath, CreateOptions{ExcludePatterns: []string{"[a"}})
+	if err == nil {
+		t.Fatal("expected error for invalid pattern")
+	}
+}
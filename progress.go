@@ -0,0 +1,83 @@
+package zipext
+
+import (
+	"io"
+	"sync"
+)
+
+// Progress observes the progress of a create or extract operation.
+// Methods are never called concurrently. When entries are processed in
+// parallel, Copied can report bytes of entries not started yet.
+type Progress interface {
+	// Started is called once, before any entry, with the number of entries
+	// and the uncompressed bytes to process, both -1 when unknown.
+	Started(entries int, bytes int64)
+	// EntryStarted is called before processing the entry name, whose
+	// uncompressed size is size.
+	EntryStarted(name string, size int64)
+	// Copied is called while copying the current entry with the number of
+	// uncompressed bytes just copied.
+	Copied(n int64)
+	// EntryFinished is called after processing the entry name, with the
+	// error stopping it if any.
+	EntryFinished(name string, err error)
+}
+
+// noProgress is used when no Progress is configured.
+type noProgress struct{}
+
+func (noProgress) Started(entries int, bytes int64)     {}
+func (noProgress) EntryStarted(name string, size int64) {}
+func (noProgress) Copied(n int64)                       {}
+func (noProgress) EntryFinished(name string, err error) {}
+
+func progressOrNoop(p Progress) Progress {
+	if p == nil {
+		return noProgress{}
+	}
+	return p
+}
+
+// progressReader reports to p the bytes read from r.
+type progressReader struct {
+	r io.Reader
+	p Progress
+}
+
+func (pr *progressReader) Read(b []byte) (int, error) {
+	n, err := pr.r.Read(b)
+	if n > 0 {
+		pr.p.Copied(int64(n))
+	}
+	return n, err
+}
+
+// syncProgress serializes the calls to p, shared by parallel workers.
+type syncProgress struct {
+	mu sync.Mutex
+	p  Progress
+}
+
+func (sp *syncProgress) Started(entries int, bytes int64) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	sp.p.Started(entries, bytes)
+}
+
+func (sp *syncProgress) EntryStarted(name string, size int64) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	sp.p.EntryStarted(name, size)
+}
+
+func (sp *syncProgress) Copied(n int64) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	sp.p.Copied(n)
+}
+
+func (sp *syncProgress) EntryFinished(name string, err error) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	sp.p.EntryFinished(name, err)
+}
@@ -0,0 +1,65 @@
+package zipext
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+type recordingProgress struct {
+	entries  int
+	bytes    int64
+	started  []string
+	finished []string
+	copied   int64
+}
+
+func (r *recordingProgress) Started(entries int, bytes int64) {
+	r.entries = entries
+	r.bytes = bytes
+}
+
+func (r *recordingProgress) EntryStarted(name string, size int64) {
+	r.started = append(r.started, name)
+}
+
+func (r *recordingProgress) Copied(n int64) {
+	r.copied += n
+}
+
+func (r *recordingProgress) EntryFinished(name string, err error) {
+	r.finished = append(r.finished, name)
+}
+
+func (r *recordingProgress) verify(t *testing.T, entries int, bytes int64) {
+	t.Helper()
+	if r.entries != entries || r.bytes != bytes {
+		t.Errorf("expected totals %d entries %d bytes but got %d %d", entries, bytes, r.entries, r.bytes)
+	}
+	if len(r.started) != entries || len(r.finished) != entries {
+		t.Errorf("expected %d started and finished entries but got %v %v", entries, r.started, r.finished)
+	}
+	if r.copied != bytes {
+		t.Errorf("expected %d copied bytes but got %d", bytes, r.copied)
+	}
+}
+
+func TestProgress(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	writeTestFile(t, filepath.Join(src, "a.txt"), "0123456789")
+	writeTestFile(t, filepath.Join(src, "sub", "b.txt"), "01234")
+	zipPath := filepath.Join(dir, "progress.zip")
+
+	createProgress := &recordingProgress{}
+	if err := CreateWithOptions(src, zipPath, CreateOptions{Progress: createProgress}); err != nil {
+		t.Fatal(err)
+	}
+	createProgress.verify(t, 2, 15)
+
+	extractProgress := &recordingProgress{}
+	opts := ExtractOptions{Progress: extractProgress}
+	if _, err := ExtractWithOptions(zipPath, filepath.Join(dir, "dest"), opts); err != nil {
+		t.Fatal(err)
+	}
+	extractProgress.verify(t, 2, 15)
+}
@@ -0,0 +1,361 @@
+package zipext
+
+import (
+	"archive/zip"
+	"bufio"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	localHeaderSignature    = 0x04034b50
+	centralHeaderSignature  = 0x02014b50
+	endSignature            = 0x06054b50
+	archiveExtraSignature   = 0x08064b50
+	dataDescriptorSignature = 0x08074b50
+
+	localHeaderLen = 30
+	zip64ExtraID   = 0x0001
+	extTimeExtraID = 0x5455
+	uint32Max      = 0xffffffff
+
+	flagEncrypted      = 0x1
+	flagDataDescriptor = 0x8
+)
+
+// ErrUnknownSize is returned (wrapped) by StreamReader for entries whose
+// size is stored only after their data, as for entries stored without
+// compression by streaming writers, which cannot be read without the
+// central directory.
+var ErrUnknownSize = errors.New("entry size unknown without the central directory")
+
+// StreamReader reads a zip sequentially from a reader, such as a pipe or a
+// network connection, parsing the local headers of the entries instead of
+// the central directory at the end.
+//
+// Local headers do not hold permissions, so files get mode 0644 and
+// directories 0755, and symbolic links are read as regular files.
+type StreamReader struct {
+	r   *countingReader
+	cur *streamBody
+	err error
+}
+
+// NewStreamReader returns a StreamReader reading from r.
+func NewStreamReader(r io.Reader) *StreamReader {
+	return &StreamReader{r: &countingReader{r: bufio.NewReader(r)}}
+}
+
+// Next skips what is left of the current entry and returns the next one,
+// or io.EOF once the central directory is reached. The entry can be opened
+// only until Next is called again.
+func (sr *StreamReader) Next() (*Entry, error) {
+	if sr.err != nil {
+		return nil, sr.err
+	}
+	if sr.cur != nil {
+		err := sr.cur.skip()
+		sr.cur = nil
+		if err != nil {
+			sr.err = err
+			return nil, err
+		}
+	}
+	entry, err := sr.readHeader()
+	if err != nil {
+		sr.err = err
+		return nil, err
+	}
+	return entry, nil
+}
+
+// readHeader reads the local header of the next entry.
+func (sr *StreamReader) readHeader() (*Entry, error) {
+	var buf [localHeaderLen]byte
+	if _, err := io.ReadFull(sr.r, buf[:4]); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	switch sig := binary.LittleEndian.Uint32(buf[:4]); sig {
+	case localHeaderSignature:
+	case centralHeaderSignature, endSignature, archiveExtraSignature:
+		return nil, io.EOF
+	default:
+		return nil, fmt.Errorf("zip: invalid local header signature %#x", sig)
+	}
+	if _, err := io.ReadFull(sr.r, buf[4:]); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	le := binary.LittleEndian
+	flags := le.Uint16(buf[6:])
+	nameLen := int(le.Uint16(buf[26:]))
+	extraLen := int(le.Uint16(buf[28:]))
+	nameExtra := make([]byte, nameLen+extraLen)
+	if _, err := io.ReadFull(sr.r, nameExtra); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	entry := &Entry{
+		Name:             string(nameExtra[:nameLen]),
+		Method:           le.Uint16(buf[8:]),
+		CRC32:            le.Uint32(buf[14:]),
+		CompressedSize:   uint64(le.Uint32(buf[18:])),
+		UncompressedSize: uint64(le.Uint32(buf[22:])),
+		Modified:         msDosTime(le.Uint16(buf[12:]), le.Uint16(buf[10:])),
+		Mode:             0644,
+	}
+	if strings.HasSuffix(entry.Name, "/") {
+		entry.Mode = os.ModeDir | 0755
+	}
+	zip64 := parseLocalExtra(entry, nameExtra[nameLen:])
+	body, err := sr.newBody(entry, flags, zip64)
+	if err != nil {
+		return nil, err
+	}
+	sr.cur = body
+	entry.open = body.open
+	return entry, nil
+}
+
+// parseLocalExtra reads sizes and modification time from the extra fields,
+// returning true if the entry uses zip64 sizes.
+func parseLocalExtra(entry *Entry, extra []byte) bool {
+	le := binary.LittleEndian
+	zip64 := false
+	for len(extra) >= 4 {
+		id := le.Uint16(extra)
+		size := int(le.Uint16(extra[2:]))
+		if size > len(extra)-4 {
+			break
+		}
+		field := extra[4 : 4+size]
+		switch {
+		case id == zip64ExtraID:
+			zip64 = true
+			if entry.UncompressedSize == uint32Max && len(field) >= 8 {
+				entry.UncompressedSize = le.Uint64(field)
+				field = field[8:]
+			}
+			if entry.CompressedSize == uint32Max && len(field) >= 8 {
+				entry.CompressedSize = le.Uint64(field)
+			}
+		case id == extTimeExtraID && size >= 5 && field[0]&1 != 0:
+			entry.Modified = time.Unix(int64(int32(le.Uint32(field[1:]))), 0).UTC()
+		}
+		extra = extra[4+size:]
+	}
+	return zip64
+}
+
+// msDosTime converts MS-DOS date and time, stored without time zone.
+func msDosTime(dosDate uint16, dosTime uint16) time.Time {
+	return time.Date(
+		int(dosDate>>9+1980), time.Month(dosDate>>5&0xf), int(dosDate&0x1f),
+		int(dosTime>>11), int(dosTime>>5&0x3f), int(dosTime&0x1f*2), 0, time.UTC)
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// newBody prepares the reader of the data following the header of entry.
+func (sr *StreamReader) newBody(entry *Entry, flags uint16, zip64 bool) (*streamBody, error) {
+	b := &streamBody{
+		r:          sr.r,
+		entry:      entry,
+		descriptor: flags&flagDataDescriptor != 0,
+		zip64:      zip64,
+		start:      sr.r.n,
+		crc:        crc32.NewIEEE(),
+	}
+	if b.descriptor {
+		// only plain deflate data ends by itself
+		if entry.Method != zip.Deflate || flags&flagEncrypted != 0 {
+			return nil, fmt.Errorf("%s: %w", entry.Name, ErrUnknownSize)
+		}
+		b.raw = sr.r
+	} else {
+		b.raw = io.LimitReader(sr.r, int64(entry.CompressedSize))
+	}
+	switch {
+	case flags&flagEncrypted != 0:
+		b.err = fmt.Errorf("%s: encrypted entries not supported", entry.Name)
+	case entry.Method == zip.Store:
+		b.data = b.raw
+	case entry.Method == zip.Deflate:
+		b.data = flate.NewReader(b.raw)
+	default:
+		b.err = fmt.Errorf("%s: unsupported compression method %d", entry.Name, entry.Method)
+	}
+	return b, nil
+}
+
+// streamBody reads the data of an entry, checking its size and checksum.
+type streamBody struct {
+	r     *countingReader
+	entry *Entry
+	// raw is the compressed data and data the uncompressed one.
+	raw  io.Reader
+	data io.Reader
+	// err is returned by open for entries that cannot be read.
+	err        error
+	descriptor bool
+	zip64      bool
+	// start is the offset of the data in the stream.
+	start    int64
+	crc      hash.Hash32
+	size     uint64
+	opened   bool
+	finished bool
+}
+
+func (b *streamBody) open() (io.ReadCloser, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	if b.opened {
+		return nil, fmt.Errorf("%s: entry already opened", b.entry.Name)
+	}
+	b.opened = true
+	return ioutil.NopCloser(b), nil
+}
+
+func (b *streamBody) Read(p []byte) (int, error) {
+	if b.finished {
+		return 0, io.EOF
+	}
+	n, err := b.data.Read(p)
+	b.crc.Write(p[:n])
+	b.size += uint64(n)
+	if err == io.EOF {
+		if ferr := b.finish(); ferr != nil {
+			return n, ferr
+		}
+	}
+	return n, err
+}
+
+// finish reads the data descriptor, if any, and checks the entry.
+func (b *streamBody) finish() error {
+	b.finished = true
+	if !b.descriptor {
+		if _, err := io.Copy(ioutil.Discard, b.raw); err != nil {
+			return err
+		}
+	} else if err := b.readDescriptor(); err != nil {
+		return err
+	}
+	if b.size != b.entry.UncompressedSize {
+		return fmt.Errorf("%s: size mismatch", b.entry.Name)
+	}
+	if b.crc.Sum32() != b.entry.CRC32 {
+		return fmt.Errorf("%s: checksum mismatch", b.entry.Name)
+	}
+	return nil
+}
+
+// readDescriptor reads checksum and sizes following the data.
+func (b *streamBody) readDescriptor() error {
+	le := binary.LittleEndian
+	compressed := uint64(b.r.n - b.start)
+	var buf [20]byte
+	if _, err := io.ReadFull(b.r, buf[:4]); err != nil {
+		return unexpectedEOF(err)
+	}
+	if le.Uint32(buf[:4]) == dataDescriptorSignature {
+		if _, err := io.ReadFull(b.r, buf[:4]); err != nil {
+			return unexpectedEOF(err)
+		}
+	}
+	b.entry.CRC32 = le.Uint32(buf[:4])
+	// writers use 64 bit sizes when either size needs them
+	if b.zip64 || compressed >= uint32Max || b.size >= uint32Max {
+		if _, err := io.ReadFull(b.r, buf[4:20]); err != nil {
+			return unexpectedEOF(err)
+		}
+		b.entry.CompressedSize = le.Uint64(buf[4:])
+		b.entry.UncompressedSize = le.Uint64(buf[12:])
+	} else {
+		if _, err := io.ReadFull(b.r, buf[4:12]); err != nil {
+			return unexpectedEOF(err)
+		}
+		b.entry.CompressedSize = uint64(le.Uint32(buf[4:]))
+		b.entry.UncompressedSize = uint64(le.Uint32(buf[8:]))
+	}
+	if b.entry.CompressedSize != compressed {
+		return fmt.Errorf("%s: compressed size mismatch", b.entry.Name)
+	}
+	return nil
+}
+
+// skip moves the stream after the entry data.
+func (b *streamBody) skip() error {
+	if b.finished {
+		return nil
+	}
+	if !b.descriptor {
+		b.finished = true
+		_, err := io.Copy(ioutil.Discard, b.raw)
+		return err
+	}
+	_, err := io.Copy(ioutil.Discard, b)
+	return err
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+func (cr *countingReader) ReadByte() (byte, error) {
+	c, err := cr.r.ReadByte()
+	if err == nil {
+		cr.n++
+	}
+	return c, err
+}
+
+// WalkStream reads the zip from r sequentially, calling walkFn for each
+// entry, as WalkEntries does for files. The entry contents can be read
+// until walkFn returns.
+func WalkStream(r io.Reader, walkFn EntryFunc) error {
+	sr := NewStreamReader(r)
+	var skippedDirs []string
+	for {
+		entry, err := sr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return skipped(walkFn(nil, err))
+		}
+		if hasAnyPrefix(entry.Name, skippedDirs) {
+			continue
+		}
+		err = walkFn(entry, nil)
+		if err == SkipDir {
+			skippedDirs = append(skippedDirs, skipPrefix(entry.Name))
+			continue
+		}
+		if err != nil {
+			return skipped(err)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package zipext
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// onlyReader hides every method but Read, as a pipe would.
+type onlyReader struct {
+	r io.Reader
+}
+
+func (o onlyReader) Read(p []byte) (int, error) {
+	return o.r.Read(p)
+}
+
+func streamOf(t *testing.T, zipPath string) io.Reader {
+	t.Helper()
+	data, err := ioutil.ReadFile(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return onlyReader{bytes.NewReader(data)}
+}
+
+func TestWalkStream(t *testing.T) {
+	modified := time.Date(2021, 3, 4, 5, 6, 8, 0, time.UTC)
+	zipPath := filepath.Join(t.TempDir(), "stream.zip")
+	writeTestZip(t, zipPath, []testEntry{
+		{name: "dir/", modified: modified},
+		{name: "dir/a.txt", body: strings.Repeat("contents", 1000), modified: modified},
+		{name: "skipped/", modified: modified},
+		{name: "skipped/b.txt", body: "skipped", modified: modified},
+		{name: "c.txt", body: "not read", modified: modified},
+	})
+	var names []string
+	var contents string
+	err := WalkStream(streamOf(t, zipPath), func(entry *Entry, err error) error {
+		if err != nil {
+			return err
+		}
+		names = append(names, entry.Name)
+		switch entry.Name {
+		case "skipped/":
+			return SkipDir
+		case "dir/a.txt":
+			if !entry.Modified.Equal(modified) {
+				t.Errorf("expected %v for %s but found %v", modified, entry.Name, entry.Modified)
+			}
+			r, err := entry.Open()
+			if err != nil {
+				return err
+			}
+			defer r.Close()
+			data, err := ioutil.ReadAll(r)
+			contents = string(data)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"dir/", "dir/a.txt", "skipped/", "c.txt"}
+	if strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected %v but found %v", expected, names)
+	}
+	if contents != strings.Repeat("contents", 1000) {
+		t.Errorf("unexpected contents of dir/a.txt: %q", contents)
+	}
+}
+
+func TestExtractStream(t *testing.T) {
+	dir := t.TempDir()
+	zipPath := filepath.Join(dir, "stream.zip")
+	writeTestZip(t, zipPath, []testEntry{
+		{name: "dir/", mode: os.ModeDir | 0755},
+		{name: "dir/a.txt", body: "a"},
+		{name: "b.txt", body: "b"},
+	})
+	extractPath := filepath.Join(dir, "out")
+	_, err := ExtractStream(streamOf(t, zipPath), extractPath, ExtractOptions{StripComponents: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertFileContent(t, filepath.Join(extractPath, "a.txt"), "a")
+	if _, err := os.Stat(filepath.Join(extractPath, "b.txt")); !os.IsNotExist(err) {
+		t.Errorf("expected b.txt stripped, got %v", err)
+	}
+}
+
+func TestExtractStreamStoredWithDescriptor(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	// a non seekable writer stores the sizes after the data
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: "stored.txt", Method: zip.Store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("stored"))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	_, err = ExtractStream(onlyReader{&buf}, filepath.Join(dir, "out"), ExtractOptions{})
+	if !errors.Is(err, ErrUnknownSize) {
+		t.Errorf("expected ErrUnknownSize but got %v", err)
+	}
+}
+
+func TestExtractStreamCreatedStored(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	writeTestFile(t, filepath.Join(src, "lib", "a.jar"), "jar")
+	writeTestFile(t, filepath.Join(src, "notes.txt"), strings.Repeat("notes ", 100))
+	if err := os.Symlink("notes.txt", filepath.Join(src, "link")); err != nil {
+		t.Skip("symlinks not supported:", err)
+	}
+	var buf bytes.Buffer
+	opts := CreateOptions{StoreSymlinks: true, Compression: &Compression{StoreExtensions: CompressedExtensions}}
+	if err := CreateTo(&buf, src, opts); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range zr.File {
+		if f.Flags&0x8 != 0 {
+			t.Errorf("expected %s without data descriptor", f.Name)
+		}
+	}
+	extractPath := filepath.Join(dir, "out")
+	if _, err := ExtractStream(onlyReader{&buf}, extractPath, ExtractOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	assertFileContent(t, filepath.Join(extractPath, "src", "lib", "a.jar"), "jar")
+	assertFileContent(t, filepath.Join(extractPath, "src", "notes.txt"), strings.Repeat("notes ", 100))
+}
+
+func TestStreamReaderChecksum(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	header := &zip.FileHeader{Name: "a.txt", Method: zip.Store, CRC32: 1, CompressedSize64: 1, UncompressedSize64: 1}
+	w, err := zw.CreateRaw(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("a"))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	sr := NewStreamReader(onlyReader{&buf})
+	entry, err := sr.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := entry.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(r); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("expected checksum error but got %v", err)
+	}
+	if _, err := sr.Next(); err != io.EOF {
+		t.Errorf("expected io.EOF but got %v", err)
+	}
+}
+
+func TestReadDescriptorZip64Uncompressed(t *testing.T) {
+	// a highly compressible entry: only the uncompressed size needs 64 bits
+	size := uint64(uint32Max) + 10
+	var desc bytes.Buffer
+	for _, v := range []interface{}{uint32(dataDescriptorSignature), uint32(7), uint64(0), size} {
+		binary.Write(&desc, binary.LittleEndian, v)
+	}
+	desc.WriteString("next")
+	b := &streamBody{
+		r:          &countingReader{r: bufio.NewReader(&desc)},
+		entry:      &Entry{Name: "big.txt"},
+		descriptor: true,
+		size:       size,
+	}
+	if err := b.readDescriptor(); err != nil {
+		t.Fatal(err)
+	}
+	if b.entry.UncompressedSize != size || b.entry.CRC32 != 7 {
+		t.Errorf("unexpected descriptor values %+v", b.entry)
+	}
+	if rest, _ := ioutil.ReadAll(b.r); string(rest) != "next" {
+		t.Errorf("stream misaligned after the descriptor: %q", rest)
+	}
+}
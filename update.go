@@ -0,0 +1,255 @@
+package zipext
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// UpdateOp is an operation applied by Update to the entries of a zip.
+type UpdateOp interface {
+	apply(u *updater) error
+}
+
+// updateEntry is an entry of the updated zip: an original entry, copied
+// raw, or a new one.
+type updateEntry struct {
+	name string
+	// file is the original entry, nil for new entries.
+	file *zip.File
+	// header and open describe a new entry.
+	header *zip.FileHeader
+	open   func() (io.ReadCloser, error)
+}
+
+// updater holds the entries while the operations are applied.
+type updater struct {
+	entries []*updateEntry
+}
+
+func (u *updater) find(name string) int {
+	for i, e := range u.entries {
+		if e.name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// put adds e, replacing the entry with the same name if any.
+func (u *updater) put(e *updateEntry) {
+	if i := u.find(e.name); i != -1 {
+		u.entries[i] = e
+		return
+	}
+	u.entries = append(u.entries, e)
+}
+
+type updateFunc func(u *updater) error
+
+func (fn updateFunc) apply(u *updater) error {
+	return fn(u)
+}
+
+// PutFile adds the file at filePath as the entry name, replacing the entry
+// already existing with that name.
+func PutFile(name string, filePath string) UpdateOp {
+	return updateFunc(func(u *updater) error {
+		if err := validEntryName(name); err != nil {
+			return err
+		}
+		fi, err := os.Stat(filePath)
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return fmt.Errorf("%s is a directory", filePath)
+		}
+		header, err := zip.FileInfoHeader(fi)
+		if err != nil {
+			return err
+		}
+		header.Name = name
+		header.Method = zip.Deflate
+		u.put(&updateEntry{name: name, header: header, open: func() (io.ReadCloser, error) {
+			return os.Open(filePath)
+		}})
+		return nil
+	})
+}
+
+// PutReader adds the contents read from r as the entry name, replacing the
+// entry already existing with that name. The entry gets mode 0644 and the
+// current time.
+func PutReader(name string, r io.Reader) UpdateOp {
+	return updateFunc(func(u *updater) error {
+		if err := validEntryName(name); err != nil {
+			return err
+		}
+		header := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()}
+		header.SetMode(0644)
+		u.put(&updateEntry{name: name, header: header, open: func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(r), nil
+		}})
+		return nil
+	})
+}
+
+// Delete removes the entries with the given names, failing if one is
+// missing.
+func Delete(names ...string) UpdateOp {
+	return updateFunc(func(u *updater) error {
+		for _, name := range names {
+			i := u.find(name)
+			if i == -1 {
+				return fmt.Errorf("%s: entry not found", name)
+			}
+			u.entries = append(u.entries[:i], u.entries[i+1:]...)
+		}
+		return nil
+	})
+}
+
+// DeleteMatching removes the entries matching any of the .gitignore style
+// patterns, see PatternSet. Directory entries match as directories, so that
+// "build/" removes the directory and all its contents.
+func DeleteMatching(patterns ...string) UpdateOp {
+	return updateFunc(func(u *updater) error {
+		ps, err := NewPatternSet(patterns)
+		if err != nil {
+			return err
+		}
+		kept := u.entries[:0]
+		for _, e := range u.entries {
+			if !ps.Match(e.name, strings.HasSuffix(e.name, "/")) {
+				kept = append(kept, e)
+			}
+		}
+		u.entries = kept
+		return nil
+	})
+}
+
+// Rename renames the entry from to to. If from ends with "/" all the
+// entries under the directory from are moved under to.
+func Rename(from string, to string) UpdateOp {
+	return updateFunc(func(u *updater) error {
+		if err := validEntryName(to); err != nil {
+			return err
+		}
+		if strings.HasSuffix(from, "/") {
+			to = strings.TrimSuffix(to, "/") + "/"
+		}
+		renamed := 0
+		for _, e := range u.entries {
+			if e.name == from || strings.HasSuffix(from, "/") && strings.HasPrefix(e.name, from) {
+				e.name = to + strings.TrimPrefix(e.name, from)
+				renamed++
+			}
+		}
+		if renamed == 0 {
+			return fmt.Errorf("%s: entry not found", from)
+		}
+		return u.checkDuplicates()
+	})
+}
+
+func (u *updater) checkDuplicates() error {
+	names := make(map[string]bool, len(u.entries))
+	for _, e := range u.entries {
+		if names[e.name] {
+			return fmt.Errorf("%s: duplicate entry", e.name)
+		}
+		names[e.name] = true
+	}
+	return nil
+}
+
+// validEntryName refuses names that would be extracted outside of the
+// destination, such as "/etc/passwd" or "../evil".
+func validEntryName(name string) error {
+	if outsideRoot(name) {
+		return fmt.Errorf("invalid entry name %q", name)
+	}
+	return nil
+}
+
+// Update applies ops, in order, to the entries of the zip at zipPath.
+// Untouched entries are copied without recompressing them. The zip is
+// rewritten in a temporary file which replaces the original only when
+// everything succeeded.
+func Update(zipPath string, ops ...UpdateOp) error {
+	realPath, err := filepath.EvalSymlinks(zipPath)
+	if err != nil {
+		return err
+	}
+	r, err := zip.OpenReader(realPath)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	u := &updater{}
+	for _, f := range r.File {
+		u.entries = append(u.entries, &updateEntry{name: f.Name, file: f})
+	}
+	for _, op := range ops {
+		if err := op.apply(u); err != nil {
+			return err
+		}
+	}
+	return writeFileAtomic(realPath, func(f *os.File) error {
+		zw := zip.NewWriter(f)
+		if err := zw.SetComment(r.Comment); err != nil {
+			return err
+		}
+		for _, e := range u.entries {
+			if err := e.write(zw); err != nil {
+				return err
+			}
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		// release the original before replacing it, as Windows requires
+		return r.Close()
+	})
+}
+
+// write writes e in zw, copying the raw data of original entries.
+func (e *updateEntry) write(zw *zip.Writer) error {
+	if e.file == nil {
+		return e.writeNew(zw)
+	}
+	header := e.file.FileHeader
+	header.Name = e.name
+	w, err := zw.CreateRaw(&header)
+	if err != nil {
+		return err
+	}
+	rc, err := e.file.OpenRaw()
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, rc)
+	return err
+}
+
+func (e *updateEntry) writeNew(zw *zip.Writer) error {
+	rc, err := e.open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	e.header.Name = e.name
+	w, err := zw.CreateHeader(e.header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, rc)
+	return err
+}
@@ -0,0 +1,108 @@
+package zipext
+
+import (
+	"archive/zip"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func readEntry(t *testing.T, zipPath string, name string) string {
+	t.Helper()
+	r, err := zip.OpenReader(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	rc, err := r.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	data, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestUpdate(t *testing.T) {
+	dir := t.TempDir()
+	zipPath := filepath.Join(dir, "app.jar")
+	writeTestZip(t, zipPath, []testEntry{
+		{name: "META-INF/MANIFEST.MF", body: "Manifest-Version: 1.0"},
+		{name: "app/Main.class", body: "main"},
+		{name: "app/Bad.class", body: "bad"},
+		{name: "logs/", mode: 0},
+		{name: "logs/debug.log", body: "debug"},
+		{name: "config.properties", body: "old"},
+	})
+	configPath := filepath.Join(dir, "config.properties")
+	writeTestFile(t, configPath, "new")
+
+	err := Update(zipPath,
+		PutFile("config.properties", configPath),
+		PutReader("VERSION", strings.NewReader("1.2.3")),
+		Delete("app/Bad.class"),
+		DeleteMatching("logs/"),
+		Rename("app/", "lib/"),
+		PutReader("NOTES", strings.NewReader("notes")),
+		Rename("NOTES", "README"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "META-INF/MANIFEST.MF,lib/Main.class,config.properties,VERSION,README"
+	if actual := strings.Join(listEntries(t, zipPath), ","); actual != expected {
+		t.Errorf("expected entries %s but got %s", expected, actual)
+	}
+	for name, body := range map[string]string{
+		"META-INF/MANIFEST.MF": "Manifest-Version: 1.0",
+		"lib/Main.class":       "main",
+		"config.properties":    "new",
+		"VERSION":              "1.2.3",
+		"README":               "notes",
+	} {
+		if actual := readEntry(t, zipPath, name); actual != body {
+			t.Errorf(`expected "%s" in %s but got "%s"`, body, name, actual)
+		}
+	}
+}
+
+func TestUpdateFailureKeepsOriginal(t *testing.T) {
+	dir := t.TempDir()
+	zipPath := filepath.Join(dir, "app.zip")
+	writeTestZip(t, zipPath, []testEntry{{name: "a.txt", body: "a"}})
+	before, err := ioutil.ReadFile(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := [][]UpdateOp{
+		{Delete("missing.txt")},
+		{PutReader("b.txt", strings.NewReader("b")), Rename("b.txt", "a.txt")},
+		{PutFile("c.txt", filepath.Join(dir, "missing"))},
+		{DeleteMatching("[a")},
+		{PutReader("../evil", strings.NewReader("evil"))},
+		{Rename("a.txt", "a/../../b")},
+	}
+	for i, ops := range tests {
+		if err := Update(zipPath, ops...); err == nil {
+			t.Errorf("%d: expected error", i)
+		}
+	}
+	after, err := ioutil.ReadFile(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(before) != string(after) {
+		t.Error("expected original zip untouched")
+	}
+	leftovers, err := filepath.Glob(filepath.Join(dir, ".*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leftovers) > 0 {
+		t.Errorf("expected no temporary files but found %v", leftovers)
+	}
+}
@@ -2,13 +2,20 @@ package zipext
 
 import (
 	"archive/zip"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"math/rand"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/enr/go-files/files"
 )
@@ -25,35 +32,114 @@ import (
 // cannot be opened). Implementations must guard against a nil file before
 // accessing any of its fields.
 //
-// TODO: this signature requires client code import archive/zip
+// WalkFunc requires client code import archive/zip: use EntryFunc and
+// WalkEntries to avoid it.
 type WalkFunc func(file *zip.File, err error) error
 
-// walk recursively descends path, calling walkFn.
-func walk(fileName string, walkFn WalkFunc) error {
-	r, err := zip.OpenReader(fileName)
+// SkipDir is used as a return value from WalkFunc and EntryFunc to indicate
+// that the entries under the directory named in the call are to be skipped.
+// If returned for a file, the entries under the directory containing the
+// file are skipped.
+var SkipDir = errors.New("skip this directory")
+
+// SkipAll is used as a return value from WalkFunc and EntryFunc to indicate
+// that all remaining entries are to be skipped. The walk returns nil.
+var SkipAll = errors.New("skip everything and stop the walk")
+
+// walk visits every entry of the zip at path, calling walkFn.
+func walk(ctx context.Context, path string, limits Limits, walkFn EntryFunc) error {
+	root := strings.TrimSpace(path)
+	_, err := os.Lstat(root)
+	if err != nil {
+		return skipped(walkFn(nil, err))
+	}
+	r, err := zip.OpenReader(root)
 	if err != nil {
-		return walkFn(nil, err)
+		return skipped(walkFn(nil, err))
 	}
 	defer r.Close()
+	l := &limiter{limits: limits}
+	var skippedDirs []string
 	for _, f := range r.File {
-		err := walkFn(f, nil)
-		if err != nil {
+		if err := ctx.Err(); err != nil {
 			return err
 		}
+		if hasAnyPrefix(f.Name, skippedDirs) {
+			continue
+		}
+		entry := newEntry(f)
+		err := walkFn(entry, l.entry(entry))
+		if err == SkipDir {
+			skippedDirs = append(skippedDirs, skipPrefix(f.Name))
+			continue
+		}
+		if err != nil {
+			return skipped(err)
+		}
 	}
 	return nil
 }
 
+// skipped filters out SkipDir and SkipAll, which are not errors.
+func skipped(err error) error {
+	if err == SkipDir || err == SkipAll {
+		return nil
+	}
+	return err
+}
+
+// skipPrefix returns the prefix of the entries skipped when walkFn returns
+// SkipDir for the entry name: the directory itself or the one containing
+// the file.
+func skipPrefix(name string) string {
+	if strings.HasSuffix(name, "/") {
+		return name
+	}
+	return name[:strings.LastIndex(name, "/")+1]
+}
+
+func hasAnyPrefix(name string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(name, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// fileFunc adapts a WalkFunc to the entries visited by walk.
+func fileFunc(walkFn WalkFunc) EntryFunc {
+	return func(entry *Entry, err error) error {
+		if entry == nil {
+			return walkFn(nil, err)
+		}
+		return walkFn(entry.file, err)
+	}
+}
+
 // Walk walks the zip file rooted at root, calling walkFn for each file or
 // directory in the zip, including root.
 // All errors that arise visiting files and directories are filtered by walkFn.
+// Entries are visited in the order they are stored; walkFn can return
+// SkipDir or SkipAll to prune the walk.
 func Walk(path string, walkFn WalkFunc) error {
-	root := strings.TrimSpace(path)
-	_, err := os.Lstat(root)
-	if err != nil {
-		return walkFn(nil, err)
-	}
-	return walk(root, walkFn)
+	return walk(context.Background(), path, Limits{}, fileFunc(walkFn))
+}
+
+// WalkContext is like Walk but stops with the context error as soon as ctx
+// is done.
+func WalkContext(ctx context.Context, path string, walkFn WalkFunc) error {
+	return walk(ctx, path, Limits{}, fileFunc(walkFn))
+}
+
+// WalkWithLimits is like Walk but checks every entry against limits.
+// When an entry crosses a limit walkFn is called with the entry and a
+// *LimitError, and the walk goes on only if walkFn returns nil.
+// Walk does not read the entries so sizes are checked against the values
+// declared in the headers; archive/zip fails reading an entry bigger than
+// its declared size.
+func WalkWithLimits(path string, limits Limits, walkFn WalkFunc) error {
+	return walk(context.Background(), path, limits, fileFunc(walkFn))
 }
 
 // IsValidZip checks if the file is detected as zip:
@@ -83,68 +169,10 @@ func IsValidZip(maybeZip string) (bool, error) {
 	return v, nil
 }
 
-// Extract contents of archivePath into the extractPath
+// Extract contents of archivePath into the extractPath.
+// Files already existing in extractPath are left untouched.
 func Extract(archivePath string, extractPath string) error {
-	zipPath := strings.TrimSpace(archivePath)
-	destinationPath := strings.TrimSpace(extractPath)
-	if zipPath == "" || destinationPath == "" {
-		return fmt.Errorf("path or destination is empty")
-	}
-	if !files.Exists(zipPath) {
-		return fmt.Errorf("%s not found", zipPath)
-	}
-	if !files.IsDir(dirname(destinationPath)) {
-		return fmt.Errorf("%s invalid path", destinationPath)
-	}
-	r, err := zip.OpenReader(zipPath)
-	if err != nil {
-		return err
-	}
-	defer r.Close()
-	destinationBaseDir := filepath.ToSlash(destinationPath)
-	if err := os.MkdirAll(destinationBaseDir, 0755); err != nil {
-		return err
-	}
-	absDestBase := filepath.Clean(destinationBaseDir)
-	for _, f := range r.File {
-		destination := filepath.Clean(filepath.Join(absDestBase, filepath.FromSlash(f.Name)))
-		rel, relErr := filepath.Rel(absDestBase, destination)
-		if relErr != nil || strings.HasPrefix(rel, "..") {
-			return fmt.Errorf("illegal file path in archive: %s", f.Name)
-		}
-		destination = filepath.ToSlash(destination)
-		if f.FileInfo().IsDir() {
-			if err := os.MkdirAll(destination, 0755); err != nil {
-				return err
-			}
-			continue
-		}
-		basepath := dirname(destination)
-		if err := os.MkdirAll(basepath, 0755); err != nil {
-			return err
-		}
-		if files.Exists(destination) {
-			continue
-		}
-		if err := extractFile(f, destination); err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
-func extractFile(f *zip.File, destination string) error {
-	s, err := f.Open()
-	if err != nil {
-		return err
-	}
-	defer s.Close()
-	d, err := os.Create(destination)
-	if err != nil {
-		return err
-	}
-	defer d.Close()
-	_, err = io.Copy(d, s)
+	_, err := ExtractWithOptions(archivePath, extractPath, ExtractOptions{})
 	return err
 }
 
@@ -156,146 +184,295 @@ func dirname(path string) string {
 	return "."
 }
 
-func addToZip(fp string, tw *zip.Writer, fi os.FileInfo, internalPath string) error {
-	ignoreBrokenSimlink := true
-	fr, err := os.Open(fp)
-	if err != nil {
-		if files.IsSymlink(fp) && ignoreBrokenSimlink {
-			return nil
-		}
-		return err
+// contextReader fails with the context error once ctx is done, so that the
+// io.Copy loops can be cancelled.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr *contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
 	}
-	defer fr.Close()
-	header, err := zip.FileInfoHeader(fi)
-	if err != nil {
-		return err
+	return cr.r.Read(p)
+}
+
+// createEntry is a file to put in the zip.
+type createEntry struct {
+	path         string
+	info         os.FileInfo
+	internalPath string
+	// fsys is the file system path belongs to, nil for the OS one.
+	fsys fs.FS
+	// reader, if not nil, opens the contents of an entry not coming from a
+	// file.
+	reader func() (io.ReadCloser, error)
+	// compression and storeSymlinks are the options of the source e comes
+	// from.
+	compression   *Compression
+	storeSymlinks bool
+}
+
+// open opens the file of e for reading.
+func (e createEntry) open() (io.ReadCloser, error) {
+	if e.reader != nil {
+		return e.reader()
 	}
-	header.Name = internalPath
-	header.Method = zip.Deflate
-	header.UncompressedSize64 = uint64(fi.Size())
-	w, err := tw.CreateHeader(header)
-	if err != nil {
-		return err
+	if e.fsys != nil {
+		return e.fsys.Open(e.path)
 	}
-	_, err = io.Copy(w, fr)
-	if err != nil {
-		return err
+	return os.Open(e.path)
+}
+
+// contents opens the data stored for e: the target of a link stored as
+// such, the contents of the file otherwise.
+func (e createEntry) contents() (io.ReadCloser, error) {
+	if e.reader == nil && e.storeSymlinks && e.isSymlink() {
+		return openSymlink(e.path)
 	}
-	return nil
+	return e.open()
+}
+
+// isBrokenLink reports whether e is a link on disk whose target is missing.
+func (e createEntry) isBrokenLink() bool {
+	return e.fsys == nil && e.reader == nil && files.IsSymlink(e.path)
+}
+
+// isSymlink reports whether e is a symbolic link not followed.
+func (e createEntry) isSymlink() bool {
+	return e.info.Mode()&os.ModeSymlink != 0
+}
+
+// size returns the bytes to copy for e.
+func (e createEntry) size() int64 {
+	if e.info.IsDir() {
+		return 0
+	}
+	return e.info.Size()
 }
 
 // Preferred ReadDir to filepath.Walk because...
 // From filepath.Walk docs:
 // for very large directories Walk can be inefficient. Walk does not follow symbolic links.
-func walkDirectory(startPath string, tw *zip.Writer, basePath2 string, ctx context) error {
+func walkDirectory(ctx context.Context, startPath string, basePath2 string, cfg createConfig, entries []createEntry) ([]createEntry, error) {
 	basePath, err := filepath.Abs(basePath2)
 	if err != nil {
-		return err
+		return entries, err
 	}
 	basePath = filepath.ToSlash(basePath)
 	dirPath, err := filepath.Abs(startPath)
 	if err != nil {
-		return err
+		return entries, err
 	}
 	dir, err := os.Open(dirPath)
 	if err != nil {
-		return err
+		return entries, err
 	}
 	defer dir.Close()
 	fis, err := dir.Readdir(0)
 	if err != nil {
-		return err
+		return entries, err
+	}
+	cfg, err = loadIgnoreFiles(dirPath, relativePath(basePath, filepath.ToSlash(dirPath)), cfg)
+	if err != nil {
+		return entries, err
 	}
 	for _, fi := range fis {
+		if err := ctx.Err(); err != nil {
+			return entries, err
+		}
 		curPath := filepath.ToSlash(filepath.Join(dirPath, fi.Name()))
-		if files.IsSamePath(curPath, ctx.zipPath) {
+		if files.IsSamePath(curPath, cfg.zipPath) || cfg.tempPath != "" && files.IsSamePath(curPath, cfg.tempPath) {
+			continue
+		}
+		internalPath := path.Join(cfg.root(basePath), relativePath(basePath, curPath))
+		if cfg.patternExcluded(relativePath(basePath, curPath), fi.IsDir()) {
 			continue
 		}
 		if fi.IsDir() {
-			err = walkDirectory(curPath, tw, basePath, ctx)
+			if cfg.directories && !cfg.exclusions.Match(internalPath+"/") {
+				entries = append(entries, createEntry{path: curPath, info: fi, internalPath: internalPath + "/"})
+			}
+			entries, err = walkDirectory(ctx, curPath, basePath, cfg, entries)
 			if err != nil {
-				return err
+				return entries, err
 			}
 		} else {
-			baseName := ""
-			if ctx.createBaseDir {
-				baseName = filepath.Base(basePath)
-			}
-			internalPath := strings.Replace(curPath, basePath, baseName, 1)
-			internalPath = strings.TrimLeft(internalPath, "/")
-			if isExcluded(internalPath, ctx.exclusions) {
+			if cfg.exclusions.Match(internalPath) {
 				continue
 			}
-			err = addToZip(curPath, tw, fi, internalPath)
-			if err != nil {
-				return err
+			if fi.Mode()&os.ModeSymlink != 0 && !cfg.storeSymlinks {
+				fi = followSymlink(curPath, fi)
 			}
+			entries = append(entries, createEntry{path: curPath, info: fi, internalPath: internalPath})
 		}
 	}
-	return nil
+	return entries, nil
+}
+
+// root returns the internal path the contents of the input directory
+// basePath are put under: its base name, the configured prefix or nothing.
+func (cfg createConfig) root(basePath string) string {
+	if cfg.createBaseDir {
+		return filepath.Base(basePath)
+	}
+	return cfg.prefix
+}
+
+// rootEntries returns the entries of the directory root and its parents,
+// with the info fi of the input directory dirPath.
+func rootEntries(root string, dirPath string, fi os.FileInfo) []createEntry {
+	var entries []createEntry
+	for p := root; p != "" && p != "."; p = path.Dir(p) {
+		entries = append([]createEntry{{path: dirPath, info: fi, internalPath: p + "/"}}, entries...)
+	}
+	return entries
+}
+
+// addEntry writes e in the zip, reporting to the configured Progress.
+// Entries go through the same steps of writeParallel, so that the zip does
+// not depend on the concurrency.
+func addEntry(ctx context.Context, tw *zip.Writer, e createEntry, cfg createConfig) error {
+	p := cfg.progress
+	p.EntryStarted(e.internalPath, e.size())
+	c := compressEntry(ctx, e, cfg)
+	err := c.err
+	if err == nil {
+		err = writeCompressedEntry(ctx, tw, e, c, cfg)
+	}
+	c.close()
+	p.EntryFinished(e.internalPath, err)
+	return err
 }
 
-type context struct {
+type createConfig struct {
 	createBaseDir bool
-	zipPath       string
-	exclusions    []string
+	// prefix is the internal path of the input directory when createBaseDir
+	// is false, empty for the root of the zip.
+	prefix  string
+	zipPath string
+	// tempPath is the file the zip is written to before renaming it.
+	tempPath      string
+	exclusions    *Matcher
+	storeSymlinks bool
+	directories   bool
+	progress      Progress
+	reproducible  bool
+	compression   *Compression
+	concurrency   int
+	// excludes and includes are matched against paths relative to the
+	// input directory.
+	excludes    *PatternSet
+	includes    *PatternSet
+	ignoreFiles []string
+	// modTime is the time timestamps are clamped to in reproducible zips.
+	modTime time.Time
 }
 
 // CreateFlat build a zip containing inputPath.
 // If inputPath is a directory the zip will contain the directory contents
 func CreateFlat(inputPath string, zipPath string) error {
-	ctx := context{
+	cfg := createConfig{
 		createBaseDir: false,
 		zipPath:       zipPath,
-		exclusions:    []string{},
 	}
-	return createZip(inputPath, zipPath, ctx)
+	return createZip(context.Background(), inputPath, zipPath, cfg)
 }
 
 // Create build a zip containing inputPath.
 // If inputPath is a directory the zip will contain the directory
 func Create(inputPath string, zipPath string) error {
-	ctx := context{
+	cfg := createConfig{
 		createBaseDir: true,
 		zipPath:       zipPath,
-		exclusions:    []string{},
 	}
-	return createZip(inputPath, zipPath, ctx)
+	return createZip(context.Background(), inputPath, zipPath, cfg)
 }
 
 // Create build a zip containing inputPath but excluding files matching `exclusions` regex.
-// If inputPath is a directory the zip will contain the directory
+// If inputPath is a directory the zip will contain the directory.
+// An invalid regex is reported before writing anything.
 func CreateExcluding(inputPath string, zipPath string, exclusions []string) error {
-	ctx := context{
+	matcher, err := NewMatcher(exclusions)
+	if err != nil {
+		return err
+	}
+	cfg := createConfig{
 		createBaseDir: true,
 		zipPath:       zipPath,
-		exclusions:    exclusions,
+		exclusions:    matcher,
 	}
-	return createZip(inputPath, zipPath, ctx)
+	return createZip(context.Background(), inputPath, zipPath, cfg)
 }
 
-func isExcluded(inputPath string, exclusions []string) bool {
-	if inputPath == "" {
-		return false
+// patternExcluded reports whether the file at rel, relative to the input
+// directory, is left out by the exclude and include patterns.
+// Include patterns only apply to files, directories are always walked.
+func (cfg createConfig) patternExcluded(rel string, isDir bool) bool {
+	if cfg.excludes.matchPath(rel, isDir) {
+		return true
+	}
+	return !isDir && cfg.includes != nil && !cfg.includes.Match(rel, false)
+}
+
+// loadIgnoreFiles adds to cfg the patterns of the ignore files found in
+// dirPath, whose path relative to the input directory is rel.
+func loadIgnoreFiles(dirPath string, rel string, cfg createConfig) (createConfig, error) {
+	for _, name := range cfg.ignoreFiles {
+		fp := filepath.Join(dirPath, name)
+		if !files.Exists(fp) || files.IsDir(fp) {
+			continue
+		}
+		excludes, err := cfg.excludes.extendFromFile(fp, rel)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.excludes = excludes
 	}
-	var matched bool
+	return cfg, nil
+}
+
+// Matcher matches internal paths against POSIX regular expressions
+// compiled once, as used by CreateExcluding.
+type Matcher struct {
+	res []*regexp.Regexp
+}
+
+// NewMatcher compiles the POSIX regular expressions exclusions, skipping the
+// empty ones. The error names the first invalid expression.
+func NewMatcher(exclusions []string) (*Matcher, error) {
+	m := &Matcher{}
 	for _, ex := range exclusions {
 		if ex == "" {
 			continue
 		}
 		r, err := regexp.CompilePOSIX(ex)
 		if err != nil {
-			continue
+			return nil, fmt.Errorf("invalid exclusion %q: %v", ex, err)
 		}
-		matched = r.MatchString(inputPath)
-		if matched {
+		m.res = append(m.res, r)
+	}
+	return m, nil
+}
+
+// Match reports whether inputPath matches any of the expressions.
+// A nil Matcher matches nothing.
+func (m *Matcher) Match(inputPath string) bool {
+	if m == nil || inputPath == "" {
+		return false
+	}
+	for _, r := range m.res {
+		if r.MatchString(inputPath) {
 			return true
 		}
 	}
 	return false
 }
 
-func createZip(inputPath string, zipPath string, ctx context) error {
+// createZip writes the zip in a temporary file renamed to zipPath once
+// complete, so that a failed build does not leave a truncated zip behind.
+func createZip(ctx context.Context, inputPath string, zipPath string, cfg createConfig) error {
 	inPath := strings.TrimSpace(inputPath)
 	outFilePath := strings.TrimSpace(zipPath)
 	if inPath == "" || outFilePath == "" {
@@ -304,30 +481,174 @@ func createZip(inputPath string, zipPath string, ctx context) error {
 	if !files.Exists(inPath) {
 		return fmt.Errorf("invalid path %s", inPath)
 	}
-	if !files.IsDir(dirname(outFilePath)) {
-		return fmt.Errorf("invalid path %s", outFilePath)
+	return createFile(outFilePath, func(f *os.File) error {
+		cfg.tempPath = f.Name()
+		return createTo(ctx, f, inPath, cfg)
+	})
+}
+
+// createFile calls write with the temporary file renamed to zipPath once
+// write succeeds.
+func createFile(zipPath string, write func(f *os.File) error) error {
+	if !files.IsDir(dirname(zipPath)) {
+		return fmt.Errorf("invalid path %s", zipPath)
+	}
+	if realPath, err := filepath.EvalSymlinks(zipPath); err == nil {
+		// replace the file linked, as writing through the link would do
+		zipPath = realPath
 	}
-	fw, err := os.Create(outFilePath)
+	return writeFileAtomic(zipPath, write)
+}
+
+// createTo writes the zip of inputPath in w.
+func createTo(ctx context.Context, w io.Writer, inputPath string, cfg createConfig) error {
+	inPath := strings.TrimSpace(inputPath)
+	if inPath == "" {
+		return fmt.Errorf("path is empty")
+	}
+	if !files.Exists(inPath) {
+		return fmt.Errorf("invalid path %s", inPath)
+	}
+	return writeZip(ctx, w, inPath, cfg)
+}
+
+// writeFileAtomic calls write with a temporary file in the directory of
+// filePath, then syncs it and renames it to filePath. On error the
+// temporary file is removed and filePath is left untouched.
+func writeFileAtomic(filePath string, write func(f *os.File) error) error {
+	tmp, err := createTemp(filePath)
+	if err != nil {
+		return err
+	}
+	err = write(tmp)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = keepMode(filePath, tmp.Name())
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filePath)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// createTemp creates a new hidden file next to filePath. Unlike
+// ioutil.TempFile permissions are the ones of os.Create.
+func createTemp(filePath string) (*os.File, error) {
+	for i := 0; ; i++ {
+		name := tempName(filePath)
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+		if os.IsExist(err) && i < 100 {
+			continue
+		}
+		return f, err
+	}
+}
+
+// tempName returns a random hidden name in the directory of p.
+func tempName(p string) string {
+	dir, base := filepath.Split(p)
+	return filepath.Join(dir, fmt.Sprintf(".%s.%d%d.tmp", base, os.Getpid(), rand.Uint32()))
+}
+
+// keepMode gives tmpPath the permissions of filePath, if it exists.
+func keepMode(filePath string, tmpPath string) error {
+	fi, err := os.Stat(filePath)
+	if err != nil {
+		return nil
+	}
+	return os.Chmod(tmpPath, fi.Mode().Perm())
+}
+
+// writeZip writes the zip of inPath in fw, failing if the central
+// directory could not be written.
+func writeZip(ctx context.Context, fw io.Writer, inPath string, cfg createConfig) error {
+	entries, err := scanInput(ctx, inPath, cfg)
 	if err != nil {
 		return err
 	}
-	defer fw.Close()
 	zw := zip.NewWriter(fw)
-	defer zw.Close()
-	if files.IsDir(inPath) {
-		err = walkDirectory(inPath, zw, inPath, ctx)
-		if err != nil {
+	if err := writeEntries(ctx, zw, entries, cfg); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// writeEntries writes entries in zw, without closing it.
+func writeEntries(ctx context.Context, zw *zip.Writer, entries []createEntry, cfg createConfig) error {
+	if cfg.reproducible {
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].internalPath < entries[j].internalPath
+		})
+	}
+	var size int64
+	for _, e := range entries {
+		size += e.size()
+	}
+	cfg.progress = progressOrNoop(cfg.progress)
+	cfg.progress.Started(len(entries), size)
+	if cfg.concurrency > 1 {
+		return writeParallel(ctx, zw, entries, cfg)
+	}
+	for _, e := range entries {
+		if err := ctx.Err(); err != nil {
 			return err
 		}
-	} else {
-		fi, err := os.Stat(inPath)
-		if err != nil {
+		if err := addEntry(ctx, zw, e, cfg); err != nil {
 			return err
 		}
-		err = addToZip(inPath, zw, fi, filepath.Base(inPath))
+	}
+	return nil
+}
+
+// scanInput lists the files to put in the zip, so that totals are known
+// before writing.
+func scanInput(ctx context.Context, inPath string, cfg createConfig) ([]createEntry, error) {
+	entries, err := scanPath(ctx, inPath, cfg)
+	for i := range entries {
+		entries[i].compression = cfg.compression
+		entries[i].storeSymlinks = cfg.storeSymlinks
+	}
+	return entries, err
+}
+
+// scanFile lists the file inPath, preceded by the directory entries of the
+// prefix, which take the info of the directory containing the file.
+func scanFile(inPath string, fi os.FileInfo, cfg createConfig) ([]createEntry, error) {
+	entry := createEntry{path: inPath, info: fi, internalPath: path.Join(cfg.prefix, filepath.Base(inPath))}
+	if !cfg.directories || cfg.prefix == "" {
+		return []createEntry{entry}, nil
+	}
+	dirPath := filepath.Dir(inPath)
+	di, err := os.Stat(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	return append(rootEntries(cfg.prefix, dirPath, di), entry), nil
+}
+
+func scanPath(ctx context.Context, inPath string, cfg createConfig) ([]createEntry, error) {
+	fi, err := os.Stat(inPath)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return scanFile(inPath, fi, cfg)
+	}
+	var entries []createEntry
+	if cfg.directories {
+		absPath, err := filepath.Abs(inPath)
 		if err != nil {
-			return err
+			return nil, err
 		}
+		entries = rootEntries(cfg.root(absPath), inPath, fi)
 	}
-	return nil
+	return walkDirectory(ctx, inPath, inPath, cfg, entries)
 }
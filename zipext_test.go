@@ -2,6 +2,8 @@ package zipext
 
 import (
 	"archive/zip"
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -131,11 +133,14 @@ var exclusionsTests = []testex{
 }
 
 func TestIsExcluded(t *testing.T) {
-	var actual bool
 	for _, ex := range exclusionsTests {
-		actual = isExcluded(ex.input, ex.exclusions)
+		m, err := NewMatcher(ex.exclusions)
+		if err != nil {
+			t.Fatalf("error compiling %v: %v", ex.exclusions, err)
+		}
+		actual := m.Match(ex.input)
 		if actual != ex.expected {
-			t.Errorf("Expected %v but got %v for isExcluded '%s' %v", ex.expected, actual, ex.input, ex.exclusions)
+			t.Errorf("Expected %v but got %v for Match '%s' %v", ex.expected, actual, ex.input, ex.exclusions)
 		}
 	}
 }
@@ -411,10 +416,27 @@ func TestExtractZipSlip(t *testing.T) {
 	}
 }
 
-func TestIsExcludedInvalidRegex(t *testing.T) {
-	// must not panic on invalid POSIX regex
-	result := isExcluded("target/foo.java", []string{"["})
-	_ = result
+func TestNewMatcherInvalidRegex(t *testing.T) {
+	_, err := NewMatcher([]string{"target", "["})
+	if err == nil {
+		t.Fatal("expected error for invalid POSIX regex")
+	}
+	if !strings.Contains(err.Error(), `"["`) {
+		t.Errorf("expected error naming the regex but got %v", err)
+	}
+}
+
+func TestCreateExcludingInvalidRegex(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	createDir(src, t)
+	zipPath := filepath.Join(dir, "invalid.zip")
+	if err := CreateExcluding(src, zipPath, []string{"["}); err == nil {
+		t.Fatal("expected error for invalid POSIX regex")
+	}
+	if files.Exists(zipPath) {
+		t.Errorf("expected %s not written", zipPath)
+	}
 }
 
 func deleteFile(path string, t *testing.T) {
@@ -432,3 +454,78 @@ func createDir(path string, t *testing.T) {
 		t.Error("error creating directory", path)
 	}
 }
+
+func TestWalkContextCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	zipPath := filepath.Join(t.TempDir(), "walk.zip")
+	writeTestZip(t, zipPath, []testEntry{{name: "a.txt"}, {name: "b.txt"}})
+	visited := 0
+	err := WalkContext(ctx, zipPath, func(f *zip.File, err error) error {
+		if err != nil {
+			return err
+		}
+		visited++
+		cancel()
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled but got %v", err)
+	}
+	if visited != 1 {
+		t.Errorf("expected 1 visited entry but got %d", visited)
+	}
+}
+
+func TestWalkSkipDirAndSkipAll(t *testing.T) {
+	zipPath := filepath.Join(t.TempDir(), "skip.zip")
+	writeTestZip(t, zipPath, []testEntry{
+		{name: "app/"},
+		{name: "app/node_modules/"},
+		{name: "app/node_modules/lib/index.js"},
+		{name: "app/main.js"},
+		{name: "app/node_modules/other.js"},
+		{name: "docs/a.md"},
+		{name: "docs/b.md"},
+		{name: "readme.txt"},
+	})
+	tests := []struct {
+		skip     func(name string) error
+		expected []string
+	}{
+		{func(name string) error {
+			if name == "app/node_modules/" {
+				return SkipDir
+			}
+			return nil
+		}, []string{"app/", "app/node_modules/", "app/main.js", "docs/a.md", "docs/b.md", "readme.txt"}},
+		{func(name string) error {
+			if name == "docs/a.md" {
+				return SkipDir
+			}
+			return nil
+		}, []string{"app/", "app/node_modules/", "app/node_modules/lib/index.js", "app/main.js", "app/node_modules/other.js", "docs/a.md", "readme.txt"}},
+		{func(name string) error {
+			if name == "app/main.js" {
+				return SkipAll
+			}
+			return nil
+		}, []string{"app/", "app/node_modules/", "app/node_modules/lib/index.js", "app/main.js"}},
+	}
+	for _, tt := range tests {
+		visited := []string{}
+		err := Walk(zipPath, func(f *zip.File, err error) error {
+			if err != nil {
+				return err
+			}
+			visited = append(visited, f.Name)
+			return tt.skip(f.Name)
+		})
+		if err != nil {
+			t.Errorf("unexpected error %v", err)
+		}
+		if !reflect.DeepEqual(visited, tt.expected) {
+			t.Errorf("expected %v but visited %v", tt.expected, visited)
+		}
+	}
+}